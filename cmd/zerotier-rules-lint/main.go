@@ -0,0 +1,89 @@
+// Command zerotier-rules-lint checks ZeroTier flow rules for statements that
+// can never match, clashing ids, undefined tags, and (given a network)
+// capabilities that no member of the network holds.
+//
+//	zerotier-rules-lint [-network <id>] [rules file]
+//
+// With -network and no file, the network's current rules are checked. The API
+// key and controller are read from ZEROTIER_API_KEY and
+// ZEROTIER_CONTROLLER_URL, like the provider.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"terraform-provider-zerotier/zerotier"
+)
+
+func main() {
+	network := flag.String("network", "", "network id, to check capabilities against its members")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [-network <id>] [rules file]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 1 || (flag.NArg() == 0 && *network == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var source string
+	if flag.NArg() == 1 {
+		b, err := ioutil.ReadFile(flag.Arg(0))
+		if err != nil {
+			fatal(err)
+		}
+		source = string(b)
+	}
+
+	var granted map[int]bool
+	if *network != "" {
+		controller := os.Getenv("ZEROTIER_CONTROLLER_URL")
+		if controller == "" {
+			controller = "https://my.zerotier.com/api"
+		}
+		client := &zerotier.ZeroTierClient{
+			ApiKey:     os.Getenv("ZEROTIER_API_KEY"),
+			Controller: controller,
+		}
+		if source == "" {
+			n, err := client.GetNetwork(*network)
+			if err != nil {
+				fatal(err)
+			}
+			source = n.RulesSource
+		}
+		members, err := client.ListMembers(*network)
+		if err != nil {
+			fatal(err)
+		}
+		granted = map[int]bool{}
+		for _, m := range members {
+			if m.Config == nil {
+				continue
+			}
+			for _, c := range m.Config.Capabilities {
+				granted[c] = true
+			}
+		}
+	}
+
+	warnings, err := zerotier.LintRules(source, nil, granted)
+	if err != nil {
+		fatal(err)
+	}
+	for _, w := range warnings {
+		fmt.Println(w)
+	}
+	if len(warnings) > 0 {
+		os.Exit(1)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(2)
+}
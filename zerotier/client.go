@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math/big"
 	"net"
 	"net/http"
 	"strings"
@@ -31,12 +32,40 @@ type V4AssignModeConfig struct {
 	ZT bool `json:"zt"`
 }
 
+type V6AssignModeConfig struct {
+	ZT       bool `json:"zt"`
+	RFC4193  bool `json:"rfc4193"`
+	SixPlane bool `json:"6plane"`
+}
+
+type DNSConfig struct {
+	Domain  string   `json:"domain"`
+	Servers []string `json:"servers"`
+}
+
+// SSOConfig has networks require members to log in with OIDC.
+type SSOConfig struct {
+	Enabled               bool   `json:"enabled"`
+	Issuer                string `json:"issuer"`
+	ClientId              string `json:"clientId"`
+	AuthorizationEndpoint string `json:"authorizationEndpoint"`
+}
+
 type Config struct {
 	Name              string             `json:"name"`
 	Private           bool               `json:"private"`
 	Routes            []Route            `json:"routes"`
 	IpAssignmentPools []IpRange          `json:"ipAssignmentPools"`
 	V4AssignMode      V4AssignModeConfig `json:"v4AssignMode"`
+	V6AssignMode      V6AssignModeConfig `json:"v6AssignMode"`
+	DNS               DNSConfig          `json:"dns"`
+	MulticastLimit    int                `json:"multicastLimit"`
+	EnableBroadcast   bool               `json:"enableBroadcast"`
+	MTU               int                `json:"mtu"`
+	// nil turns remote tracing off
+	RemoteTraceTarget *string   `json:"remoteTraceTarget"`
+	RemoteTraceLevel  int       `json:"remoteTraceLevel"`
+	SSOConfig         SSOConfig `json:"ssoConfig"`
 }
 
 type ConfigReadOnly struct {
@@ -46,7 +75,7 @@ type ConfigReadOnly struct {
 	// so, we only want them when reading from the API
 	// this struct lets you do that
 	Tags         []Tag        `json:"tags"`
-	Rules        []IRule      `json:"rules"`
+	Rules        []Rule       `json:"rules"`
 	Capabilities []Capability `json:"capabilities"`
 
 	CreationTime int64 `json:"creationTime"`
@@ -68,12 +97,16 @@ type NetworkReadOnly struct {
 	Config             *ConfigReadOnly      `json:"config"`
 	TagsByName         map[string]TagByName `json:"tagsByName"`
 	CapabilitiesByName map[string]int       `json:"capabilitiesByName"`
+
+	OnlineMemberCount     int `json:"onlineMemberCount"`
+	AuthorizedMemberCount int `json:"authorizedMemberCount"`
+	TotalMemberCount      int `json:"totalMemberCount"`
 }
 
 type Capability struct {
-	Id      int     `json:"id"`
-	Default bool    `json:"default"`
-	Rules   []IRule `json:"rules"`
+	Id      int    `json:"id"`
+	Default bool   `json:"default"`
+	Rules   []Rule `json:"rules"`
 }
 
 type Tag struct {
@@ -81,11 +114,6 @@ type Tag struct {
 	Default *int `json:"default"`
 }
 
-type IRule interface {
-	// default unmarshaljson just makes a
-	// map[string]interface{} from { type: "ACTION_DROP" } etc
-}
-
 type TagByName struct {
 	Tag
 	Enums map[string]int `json:"enums"`
@@ -109,62 +137,87 @@ type MemberConfig struct {
 	ActiveBridge    bool     `json:"activeBridge"`
 	NoAutoAssignIps bool     `json:"noAutoAssignIps"`
 	IpAssignments   []string `json:"ipAssignments"`
+	SSOExempt       bool     `json:"ssoExempt"`
+}
+
+// MemberReadOnly is a member as read from the API, with fields Central sets
+// that shouldn't be sent back.
+type MemberReadOnly struct {
+	Member
+	Config *MemberConfigReadOnly `json:"config"`
+
+	Online bool `json:"online"`
+	// milliseconds since the epoch
+	LastOnline      int64  `json:"lastOnline"`
+	PhysicalAddress string `json:"physicalAddress"`
+	ClientVersion   string `json:"clientVersion"`
+	ProtocolVersion int    `json:"protocolVersion"`
 }
 type MemberConfigReadOnly struct {
-	CreationTime       int `json:"creationTime"`
-	LastAuthorizedTime int `json:"lastAuthorizedTime"`
-	VMajor             int `json:"vMajor"`
-	VMinor             int `json:"vMinor"`
-	VRev               int `json:"vRev"`
-	VProto             int `json:"vProto"`
+	MemberConfig
+
+	CreationTime       int64 `json:"creationTime"`
+	LastAuthorizedTime int64 `json:"lastAuthorizedTime"`
+	VMajor             int   `json:"vMajor"`
+	VMinor             int   `json:"vMinor"`
+	VRev               int   `json:"vRev"`
+	VProto             int   `json:"vProto"`
+	// milliseconds since the epoch, when an SSO member has to log in again
+	AuthenticationExpiryTime int64 `json:"authenticationExpiryTime"`
 }
 
+// CIDRToRange returns the first and last addresses in an IPv4 or IPv6 cidr.
 func CIDRToRange(cidr string) (net.IP, net.IP, error) {
-	ip, ipnet, err := net.ParseCIDR(cidr)
+	_, ipnet, err := net.ParseCIDR(cidr)
 	if err != nil {
 		return nil, nil, err
 	}
-	first := ip.Mask(ipnet.Mask)
-	last := make(net.IP, 4)
-	for ip := ip.Mask(ipnet.Mask); ipnet.Contains(ip); inc(ip) {
-		copy(last, ip)
-	}
-	// mirror what ZT console does
-	// there must be a reason
-	if first[3] == 0 {
-		first[3] = 1
-	}
-	if last[3] == 255 {
-		last[3] = 254
-	}
-	return first, last, nil
-
-}
-
-// modifies existing net.IP
-func inc(ip net.IP) {
-	for j := len(ip) - 1; j >= 0; j-- {
-		ip[j]++
-		if ip[j] > 0 {
-			break
+	ones, bits := ipnet.Mask.Size()
+	first := ipToInt(ipnet.IP)
+	last := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	last.Sub(last, big.NewInt(1))
+	last.Add(last, first)
+	// mirror what ZT console does, and leave out the network and broadcast
+	// addresses, where there are any
+	if bits == 32 && ones <= 30 {
+		if new(big.Int).And(first, big.NewInt(0xff)).Sign() == 0 {
+			first.Add(first, big.NewInt(1))
+		}
+		if new(big.Int).And(last, big.NewInt(0xff)).Int64() == 0xff {
+			last.Sub(last, big.NewInt(1))
 		}
 	}
+	return intToIP(first, bits), intToIP(last, bits), nil
 }
 
 // not perfect, but allocation ranges should probably always be cidrs
 func SmallestCIDR(from net.IP, to net.IP) string {
-	maxLen := 32
-	for l := maxLen; l >= 0; l-- {
-		mask := net.CIDRMask(l, maxLen)
-		na := from.Mask(mask)
-		n := net.IPNet{IP: na, Mask: mask}
-
-		if n.Contains(to) {
-			return fmt.Sprintf("%v/%v", na, l)
-		}
+	bits := 128
+	if from.To4() != nil && to.To4() != nil {
+		bits = 32
+	} else if from.To4() != nil || to.To4() != nil || from.To16() == nil || to.To16() == nil {
+		// return a string so it shows up in any CLI diffs
+		return "unable to figure out CIDR from range"
 	}
-	// return a string so it shows up in any CLI diffs
-	return "unable to figure out CIDR from range"
+	start := ipToInt(from)
+	// the prefix is as long as the bits the two addresses have in common
+	ones := bits - new(big.Int).Xor(start, ipToInt(to)).BitLen()
+	mask := net.CIDRMask(ones, bits)
+	return fmt.Sprintf("%v/%v", intToIP(start, bits).Mask(mask), ones)
+}
+
+func ipToInt(ip net.IP) *big.Int {
+	if v4 := ip.To4(); v4 != nil {
+		return new(big.Int).SetBytes(v4)
+	}
+	return new(big.Int).SetBytes(ip.To16())
+}
+
+func intToIP(i *big.Int, bits int) net.IP {
+	ip := make(net.IP, bits/8)
+	b := i.Bytes()
+	copy(ip[len(ip)-len(b):], b)
+	return ip
 }
 
 func (s *ZeroTierClient) doRequest(reqName string, req *http.Request) ([]byte, error) {
@@ -217,7 +270,7 @@ func (client *ZeroTierClient) CheckNetworkExists(id string) (bool, error) {
 	return true, err
 }
 
-func (client *ZeroTierClient) GetNetwork(id string) (*Network, error) {
+func (client *ZeroTierClient) GetNetwork(id string) (*NetworkReadOnly, error) {
 	url := fmt.Sprintf(client.Controller+"/network/%s", id)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
@@ -227,7 +280,7 @@ func (client *ZeroTierClient) GetNetwork(id string) (*Network, error) {
 	if err != nil {
 		return nil, err
 	}
-	var data Network
+	var data NetworkReadOnly
 	err = json.Unmarshal(bytes, &data)
 	if err != nil {
 		return nil, err
@@ -235,7 +288,7 @@ func (client *ZeroTierClient) GetNetwork(id string) (*Network, error) {
 	return &data, nil
 }
 
-func (client *ZeroTierClient) postNetwork(id string, network *Network) (*Network, error) {
+func (client *ZeroTierClient) postNetwork(id string, network *Network) (*NetworkReadOnly, error) {
 	url := strings.TrimSuffix(fmt.Sprintf(client.Controller+"/network/%s", id), "/")
 	// strip carriage returns?
 	// network.RulesSource = strings.Replace(network.RulesSource, "\r", "", -1)
@@ -257,7 +310,7 @@ func (client *ZeroTierClient) postNetwork(id string, network *Network) (*Network
 	if err != nil {
 		return nil, err
 	}
-	var data Network
+	var data NetworkReadOnly
 	err = json.Unmarshal(bytes, &data)
 	if err != nil {
 		return nil, err
@@ -265,11 +318,11 @@ func (client *ZeroTierClient) postNetwork(id string, network *Network) (*Network
 	return &data, nil
 }
 
-func (client *ZeroTierClient) CreateNetwork(network *Network) (*Network, error) {
+func (client *ZeroTierClient) CreateNetwork(network *Network) (*NetworkReadOnly, error) {
 	return client.postNetwork("", network)
 }
 
-func (client *ZeroTierClient) UpdateNetwork(id string, network *Network) (*Network, error) {
+func (client *ZeroTierClient) UpdateNetwork(id string, network *Network) (*NetworkReadOnly, error) {
 	return client.postNetwork(id, network)
 }
 
@@ -287,7 +340,7 @@ func (client *ZeroTierClient) DeleteNetwork(id string) error {
 // members //
 /////////////
 
-func (client *ZeroTierClient) GetMember(nwid string, nodeId string) (*Member, error) {
+func (client *ZeroTierClient) GetMember(nwid string, nodeId string) (*MemberReadOnly, error) {
 	url := fmt.Sprintf(client.Controller+"/network/%s/member/%s", nwid, nodeId)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
@@ -297,7 +350,7 @@ func (client *ZeroTierClient) GetMember(nwid string, nodeId string) (*Member, er
 	if err != nil {
 		return nil, err
 	}
-	var data Member
+	var data MemberReadOnly
 	err = json.Unmarshal(bytes, &data)
 	if err != nil {
 		return nil, err
@@ -305,7 +358,25 @@ func (client *ZeroTierClient) GetMember(nwid string, nodeId string) (*Member, er
 	return &data, nil
 }
 
-func (client *ZeroTierClient) postMember(member *Member, reqName string) (*Member, error) {
+func (client *ZeroTierClient) ListMembers(nwid string) ([]MemberReadOnly, error) {
+	url := fmt.Sprintf(client.Controller+"/network/%s/member", nwid)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	bytes, err := client.doRequest("ListMembers", req)
+	if err != nil {
+		return nil, err
+	}
+	var data []MemberReadOnly
+	err = json.Unmarshal(bytes, &data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (client *ZeroTierClient) postMember(member *Member, reqName string) (*MemberReadOnly, error) {
 	url := fmt.Sprintf(client.Controller+"/network/%s/member/%s", member.NetworkId, member.NodeId)
 	j, err := json.Marshal(member)
 	if err != nil {
@@ -319,7 +390,7 @@ func (client *ZeroTierClient) postMember(member *Member, reqName string) (*Membe
 	if err != nil {
 		return nil, err
 	}
-	var data Member
+	var data MemberReadOnly
 	err = json.Unmarshal(bytes, &data)
 	if err != nil {
 		return nil, err
@@ -327,11 +398,11 @@ func (client *ZeroTierClient) postMember(member *Member, reqName string) (*Membe
 	return &data, nil
 }
 
-func (client *ZeroTierClient) CreateMember(member *Member) (*Member, error) {
+func (client *ZeroTierClient) CreateMember(member *Member) (*MemberReadOnly, error) {
 	return client.postMember(member, "CreateMember")
 }
 
-func (client *ZeroTierClient) UpdateMember(member *Member) (*Member, error) {
+func (client *ZeroTierClient) UpdateMember(member *Member) (*MemberReadOnly, error) {
 	return client.postMember(member, "UpdateMember")
 }
 
@@ -0,0 +1,84 @@
+package zerotier
+
+import (
+	"net"
+	"testing"
+)
+
+func TestCIDRToRange(t *testing.T) {
+	cases := []struct {
+		cidr, first, last string
+	}{
+		// like ZT console, addresses ending .0 and .255 are left out of ranges
+		// big enough to have them
+		{"10.0.0.0/24", "10.0.0.1", "10.0.0.254"},
+		{"10.0.0.0/16", "10.0.0.1", "10.0.255.254"},
+		{"10.0.0.0/30", "10.0.0.1", "10.0.0.3"},
+		{"10.0.0.4/30", "10.0.0.4", "10.0.0.7"},
+		{"10.0.0.0/31", "10.0.0.0", "10.0.0.1"},
+		{"10.0.0.254/31", "10.0.0.254", "10.0.0.255"},
+		{"10.0.0.7/32", "10.0.0.7", "10.0.0.7"},
+		{"10.0.0.255/32", "10.0.0.255", "10.0.0.255"},
+		{"0.0.0.0/0", "0.0.0.1", "255.255.255.254"},
+		{"fd00::/64", "fd00::", "fd00::ffff:ffff:ffff:ffff"},
+		{"fd00::ff/127", "fd00::fe", "fd00::ff"},
+		{"fd00::ff/128", "fd00::ff", "fd00::ff"},
+		{"::/0", "::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"},
+	}
+	for _, c := range cases {
+		first, last, err := CIDRToRange(c.cidr)
+		if err != nil {
+			t.Errorf("%s: %s", c.cidr, err)
+			continue
+		}
+		if first.String() != c.first || last.String() != c.last {
+			t.Errorf("%s: got %s-%s, want %s-%s", c.cidr, first, last, c.first, c.last)
+		}
+	}
+
+	if _, _, err := CIDRToRange("10.0.0.0/33"); err == nil {
+		t.Errorf("10.0.0.0/33: expected an error")
+	}
+}
+
+func TestSmallestCIDR(t *testing.T) {
+	cases := []struct {
+		from, to, cidr string
+	}{
+		{"10.0.0.1", "10.0.0.254", "10.0.0.0/24"},
+		{"10.0.0.0", "10.0.0.1", "10.0.0.0/31"},
+		{"10.0.0.7", "10.0.0.7", "10.0.0.7/32"},
+		{"10.0.0.1", "10.0.1.1", "10.0.0.0/23"},
+		{"0.0.0.1", "255.255.255.254", "0.0.0.0/0"},
+		{"fd00::", "fd00::ffff:ffff:ffff:ffff", "fd00::/64"},
+		{"fd00::fe", "fd00::ff", "fd00::fe/127"},
+		{"fd00::ff", "fd00::ff", "fd00::ff/128"},
+		{"::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "::/0"},
+		// the families don't mix
+		{"10.0.0.1", "fd00::1", "unable to figure out CIDR from range"},
+		{"fd00::1", "10.0.0.1", "unable to figure out CIDR from range"},
+	}
+	for _, c := range cases {
+		if got := SmallestCIDR(net.ParseIP(c.from), net.ParseIP(c.to)); got != c.cidr {
+			t.Errorf("%s-%s: got %s, want %s", c.from, c.to, got, c.cidr)
+		}
+	}
+
+	if got := SmallestCIDR(nil, net.ParseIP("10.0.0.1")); got != "unable to figure out CIDR from range" {
+		t.Errorf("nil-10.0.0.1: got %s", got)
+	}
+}
+
+// Every range from CIDRToRange should map back to its cidr.
+func TestCIDRToRangeRoundTrip(t *testing.T) {
+	for _, cidr := range []string{"10.0.0.0/8", "192.168.1.0/24", "192.168.1.128/25", "10.1.2.0/31", "10.1.2.3/32", "fd12:3456::/48", "fd12:3456::/127", "fd12:3456::1/128"} {
+		first, last, err := CIDRToRange(cidr)
+		if err != nil {
+			t.Errorf("%s: %s", cidr, err)
+			continue
+		}
+		if got := SmallestCIDR(first, last); got != cidr {
+			t.Errorf("%s: range %s-%s maps back to %s", cidr, first, last, got)
+		}
+	}
+}
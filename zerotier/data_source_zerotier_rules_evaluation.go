@@ -0,0 +1,355 @@
+package zerotier
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/hashicorp/terraform/helper/hashcode"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func dataSourceZeroTierRulesEvaluation() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourceRulesEvaluationRead,
+
+		Schema: map[string]*schema.Schema{
+			"rules_source": &schema.Schema{
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"network_id"},
+				Description:   "Rules to evaluate. Either this or network_id is required.",
+			},
+			"rules_includes": &schema.Schema{
+				Type:          schema.TypeMap,
+				Optional:      true,
+				ConflictsWith: []string{"network_id"},
+				Description:   "Snippets that rules_source can include, as on zerotier_network.",
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"network_id": &schema.Schema{
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"rules_source"},
+				Description:   "Evaluate the rules a network currently has.",
+			},
+
+			// the packet
+			"ethertype": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "ipv4",
+				Description: "Name or number, as in the rules language.",
+			},
+			"ip_protocol": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Name or number, as in the rules language, eg tcp.",
+			},
+			"source_ip": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"dest_ip": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"source_port": &schema.Schema{
+				Type:     schema.TypeInt,
+				Optional: true,
+			},
+			"dest_port": &schema.Schema{
+				Type:     schema.TypeInt,
+				Optional: true,
+			},
+			"icmp_type": &schema.Schema{
+				Type:     schema.TypeInt,
+				Optional: true,
+			},
+			"icmp_code": &schema.Schema{
+				Type:     schema.TypeInt,
+				Optional: true,
+			},
+			"ip_tos": &schema.Schema{
+				Type:     schema.TypeInt,
+				Optional: true,
+			},
+			"frame_size": &schema.Schema{
+				Type:     schema.TypeInt,
+				Optional: true,
+				Default:  1500,
+			},
+			"characteristics": &schema.Schema{
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Names matched by chr, eg tcp_syn or ipauth. inbound is worked out for you.",
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"source_address": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "ZeroTier address (node id) of the sender.",
+			},
+			"dest_address": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "ZeroTier address (node id) of the receiver.",
+			},
+			"source_mac": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"dest_mac": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"source_tags": &schema.Schema{
+				Type:        schema.TypeMap,
+				Optional:    true,
+				Description: "Tags of the sender, by tag name or id, to a value or enum/flag name. Tags with defaults needn't be given.",
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"dest_tags": &schema.Schema{
+				Type:        schema.TypeMap,
+				Optional:    true,
+				Description: "Tags of the receiver, like source_tags.",
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"source_capabilities": &schema.Schema{
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Capabilities held by the sender, by name or id.",
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+
+			// the verdict
+			"action": &schema.Schema{
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "accept, drop or redirect.",
+			},
+			"matched_rule": &schema.Schema{
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The statement that decided, or empty if the packet was dropped because nothing accepted it.",
+			},
+			"matched_capability": &schema.Schema{
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The capability matched_rule belongs to, if any.",
+			},
+			"direction": &schema.Schema{
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Whether the sender (outbound) or the receiver (inbound) decided.",
+			},
+		},
+	}
+}
+
+func dataSourceRulesEvaluationRead(d *schema.ResourceData, m interface{}) error {
+	var c *compiledRules
+	var id string
+	if nwid := d.Get("network_id").(string); nwid != "" {
+		client := m.(*ZeroTierClient)
+		n, err := client.GetNetwork(nwid)
+		if err != nil {
+			return fmt.Errorf("unable to read network from API: %s", err)
+		}
+		c = compiledFromNetwork(n)
+		id = nwid
+	} else {
+		// otherwise everything would be dropped by empty rules, and a check
+		// expecting a drop would pass without any rules being given
+		raw, ok := d.GetOk("rules_source")
+		if !ok {
+			return fmt.Errorf("one of rules_source and network_id is required")
+		}
+		source := raw.(string)
+		compiled, err := compileRules(source, rulesIncludes(d))
+		if err != nil {
+			return fmt.Errorf("rules_source: %s", err)
+		}
+		c = compiled
+		id = strconv.Itoa(hashcode.String(source))
+	}
+
+	p, err := rulePacketFromResourceData(d, c)
+	if err != nil {
+		return err
+	}
+	verdict := evaluateRules(c, p)
+
+	d.SetId(id)
+	d.Set("action", verdict.action)
+	d.Set("matched_rule", verdict.rule)
+	d.Set("matched_capability", verdict.capability)
+	d.Set("direction", verdict.direction)
+	return nil
+}
+
+func rulePacketFromResourceData(d *schema.ResourceData, c *compiledRules) (*rulePacket, error) {
+	etherType, ok := lookupRuleName(d.Get("ethertype").(string), ruleEtherTypes)
+	if !ok {
+		return nil, fmt.Errorf("unknown ethertype %q", d.Get("ethertype").(string))
+	}
+	p := &rulePacket{
+		etherType:     etherType,
+		ipProtocol:    -1,
+		sourcePort:    d.Get("source_port").(int),
+		destPort:      d.Get("dest_port").(int),
+		icmpType:      d.Get("icmp_type").(int),
+		icmpCode:      d.Get("icmp_code").(int),
+		ipTos:         d.Get("ip_tos").(int),
+		frameSize:     d.Get("frame_size").(int),
+		sourceAddress: d.Get("source_address").(string),
+		destAddress:   d.Get("dest_address").(string),
+	}
+	if proto := d.Get("ip_protocol").(string); proto != "" {
+		if p.ipProtocol, ok = lookupRuleName(proto, ruleIpProtocols); !ok {
+			return nil, fmt.Errorf("unknown ip_protocol %q", proto)
+		}
+	}
+	for _, k := range []string{"source_ip", "dest_ip"} {
+		s := d.Get(k).(string)
+		if s == "" {
+			continue
+		}
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("%s: %q is not an IP address", k, s)
+		}
+		if k == "source_ip" {
+			p.sourceIp = ip
+		} else {
+			p.destIp = ip
+		}
+	}
+	for _, k := range []string{"source_mac", "dest_mac"} {
+		s := d.Get(k).(string)
+		if s == "" {
+			continue
+		}
+		mac, err := net.ParseMAC(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", k, err)
+		}
+		if k == "source_mac" {
+			p.sourceMac = mac
+		} else {
+			p.destMac = mac
+		}
+	}
+	if p.destMac != nil {
+		if p.destMac.String() == "ff:ff:ff:ff:ff:ff" {
+			p.characteristics |= 0x2000000000000000
+		} else if p.destMac[0]&1 == 1 {
+			p.characteristics |= 0x4000000000000000
+		}
+	}
+	for _, raw := range d.Get("characteristics").([]interface{}) {
+		name := raw.(string)
+		found := false
+		for _, chr := range ruleCharacteristics {
+			if chr.name == name {
+				if chr.bit != characteristicInbound {
+					p.characteristics |= chr.bit
+				}
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("characteristics: unknown packet characteristic %q", name)
+		}
+	}
+
+	var err error
+	if p.sourceTags, err = resolveTags(d.Get("source_tags").(map[string]interface{}), c); err != nil {
+		return nil, fmt.Errorf("source_tags: %s", err)
+	}
+	if p.destTags, err = resolveTags(d.Get("dest_tags").(map[string]interface{}), c); err != nil {
+		return nil, fmt.Errorf("dest_tags: %s", err)
+	}
+	if p.sourceCaps, err = resolveCapabilities(d.Get("source_capabilities").([]interface{}), c.CapabilitiesByName); err != nil {
+		return nil, fmt.Errorf("source_capabilities: %s", err)
+	}
+	return p, nil
+}
+
+// resolveCapabilities turns capabilities given by name or id into ids.
+func resolveCapabilities(raw []interface{}, capsByName map[string]int) ([]int, error) {
+	var caps []int
+	for _, r := range raw {
+		name := r.(string)
+		if id, ok := capsByName[name]; ok {
+			caps = append(caps, id)
+		} else if id, err := strconv.Atoi(name); err == nil {
+			caps = append(caps, id)
+		} else {
+			return nil, fmt.Errorf("capability %q is not defined", name)
+		}
+	}
+	return caps, nil
+}
+
+// resolveTags turns tags given by name or id, with values given by number or
+// enum/flag name, into tag id -> value, filling in any defaults.
+func resolveTags(raw map[string]interface{}, c *compiledRules) (map[int]int, error) {
+	tags := map[int]int{}
+	for _, t := range c.Tags {
+		if t.Default != nil {
+			tags[t.Id] = *t.Default
+		}
+	}
+	given, err := resolveTagValues(raw, c.TagsByName)
+	if err != nil {
+		return nil, err
+	}
+	for id, v := range given {
+		tags[id] = v
+	}
+	return tags, nil
+}
+
+// resolveTagValues turns tags given by name or id, with values given by
+// number or enum/flag name, into tag id -> value.
+func resolveTagValues(raw map[string]interface{}, tagsByName map[string]TagByName) (map[int]int, error) {
+	tags := map[int]int{}
+	for key, rawValue := range raw {
+		value := rawValue.(string)
+		tag, named := tagsByName[key]
+		if !named {
+			id, err := strconv.Atoi(key)
+			if err != nil {
+				return nil, fmt.Errorf("tag %q is not defined", key)
+			}
+			tag.Id = id
+			// it may still have a name, and enums
+			for _, t := range tagsByName {
+				if t.Id == id {
+					tag = t
+				}
+			}
+		}
+		if v, err := strconv.Atoi(value); err == nil {
+			tags[tag.Id] = v
+		} else if v, ok := tag.Enums[value]; ok {
+			tags[tag.Id] = v
+		} else if bit, ok := tag.Flags[value]; ok {
+			tags[tag.Id] = 1 << uint(bit)
+		} else {
+			return nil, fmt.Errorf("%q is not a value of tag %s", value, key)
+		}
+	}
+	return tags, nil
+}
@@ -0,0 +1,30 @@
+package zerotier
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func TestRulesEvaluationNeedsRules(t *testing.T) {
+	r := dataSourceZeroTierRulesEvaluation()
+	for _, raw := range []map[string]interface{}{
+		{},
+		{"rules_source": ""},
+	} {
+		d := schema.TestResourceDataRaw(t, r.Schema, raw)
+		err := dataSourceRulesEvaluationRead(d, nil)
+		if err == nil || !strings.Contains(err.Error(), "one of rules_source and network_id is required") {
+			t.Errorf("%v: got %v, want an error asking for rules", raw, err)
+		}
+	}
+
+	d := schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{"rules_source": "accept;"})
+	if err := dataSourceRulesEvaluationRead(d, nil); err != nil {
+		t.Fatal(err)
+	}
+	if d.Get("action").(string) != "accept" {
+		t.Errorf("got %q for accept;", d.Get("action"))
+	}
+}
@@ -0,0 +1,221 @@
+package zerotier
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/terraform/config"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// fakeController is just enough of Central's API to apply and read networks
+// and members, keeping what was POSTed so tests can check the JSON sent.
+type fakeController struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu       sync.Mutex
+	networks map[string]*NetworkReadOnly
+	members  map[string]*MemberReadOnly
+	// the last body POSTed to each path
+	posted map[string][]byte
+}
+
+const fakeNetworkId = "8056c2e21c000001"
+
+func newFakeController(t *testing.T) *fakeController {
+	f := &fakeController{
+		t:        t,
+		networks: map[string]*NetworkReadOnly{},
+		members:  map[string]*MemberReadOnly{},
+		posted:   map[string][]byte{},
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+func (f *fakeController) Close() {
+	f.server.Close()
+}
+
+func (f *fakeController) client() *ZeroTierClient {
+	return &ZeroTierClient{ApiKey: "test", Controller: f.server.URL}
+}
+
+func (f *fakeController) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) == 0 || parts[0] != "network" {
+		http.NotFound(w, r)
+		return
+	}
+	var body []byte
+	if r.Method == "POST" {
+		body, _ = ioutil.ReadAll(r.Body)
+		f.posted[r.URL.Path] = body
+	}
+
+	var reply interface{}
+	switch {
+	case len(parts) <= 2 && r.Method == "POST":
+		id := fakeNetworkId
+		if len(parts) == 2 {
+			id = parts[1]
+		}
+		n := &NetworkReadOnly{}
+		if old, ok := f.networks[id]; ok {
+			*n = *old
+		}
+		posted := &NetworkReadOnly{Config: &ConfigReadOnly{}}
+		if err := json.Unmarshal(body, posted); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		n.Id = id
+		if posted.Description != "" {
+			n.Description = posted.Description
+		}
+		if posted.RulesSource != "" {
+			n.RulesSource = posted.RulesSource
+		}
+		if strings.Contains(string(body), `"config"`) {
+			if n.Config != nil {
+				posted.Config.Tags, posted.Config.Rules, posted.Config.Capabilities = n.Config.Tags, n.Config.Rules, n.Config.Capabilities
+			}
+			n.Config = posted.Config
+		}
+		f.networks[id] = n
+		reply = n
+	case len(parts) == 2 && r.Method == "GET":
+		n, ok := f.networks[parts[1]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		reply = n
+	case len(parts) == 2 && r.Method == "DELETE":
+		delete(f.networks, parts[1])
+		reply = struct{}{}
+	case len(parts) == 3 && parts[2] == "member" && r.Method == "GET":
+		members := []MemberReadOnly{}
+		for _, m := range f.members {
+			if m.NetworkId == parts[1] {
+				members = append(members, *m)
+			}
+		}
+		reply = members
+	case len(parts) == 4 && parts[2] == "member":
+		key := fmt.Sprintf("%s-%s", parts[1], parts[3])
+		switch r.Method {
+		case "POST":
+			m := &MemberReadOnly{}
+			if err := json.Unmarshal(body, m); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			m.Id, m.NetworkId, m.NodeId = key, parts[1], parts[3]
+			f.members[key] = m
+			reply = m
+		case "GET":
+			m, ok := f.members[key]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			reply = m
+		case "DELETE":
+			delete(f.members, key)
+			reply = struct{}{}
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(reply)
+}
+
+// lastPosted unmarshals the last body POSTed to path into v.
+func (f *fakeController) lastPosted(path string, v interface{}) {
+	f.t.Helper()
+	f.mu.Lock()
+	body, ok := f.posted[path]
+	f.mu.Unlock()
+	if !ok {
+		f.t.Fatalf("nothing was POSTed to %s", path)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		f.t.Fatalf("POST to %s: %s: %s", path, err, body)
+	}
+}
+
+// apply plans raw as the config of r against state, applies it, and refreshes,
+// the way Terraform would.
+func (f *fakeController) apply(r *schema.Resource, state *terraform.InstanceState, raw map[string]interface{}) *terraform.InstanceState {
+	f.t.Helper()
+	c, err := config.NewRawConfig(raw)
+	if err != nil {
+		f.t.Fatalf("config: %s", err)
+	}
+	rc := terraform.NewResourceConfig(c)
+	if _, errs := r.Validate(rc); len(errs) > 0 {
+		f.t.Fatalf("validate: %v", errs)
+	}
+	diff, err := r.Diff(state, rc, f.client())
+	if err != nil {
+		f.t.Fatalf("plan: %s", err)
+	}
+	if diff == nil {
+		return state
+	}
+	state, err = r.Apply(state, diff, f.client())
+	if err != nil {
+		f.t.Fatalf("apply: %s", err)
+	}
+	state, err = r.Refresh(state, f.client())
+	if err != nil {
+		f.t.Fatalf("refresh: %s", err)
+	}
+	return state
+}
+
+// importState imports id as r and refreshes it, the way terraform import would.
+func (f *fakeController) importState(r *schema.Resource, id string) *terraform.InstanceState {
+	f.t.Helper()
+	imported, err := r.Importer.State(r.Data(&terraform.InstanceState{ID: id}), f.client())
+	if err != nil {
+		f.t.Fatalf("import: %s", err)
+	}
+	state, err := r.Refresh(imported[0].State(), f.client())
+	if err != nil {
+		f.t.Fatalf("refresh: %s", err)
+	}
+	if state == nil {
+		f.t.Fatalf("import: %s doesn't exist", id)
+	}
+	return state
+}
+
+// existingNetwork stores a network that was set up outside Terraform, with
+// rules compiled from source and no rulesSource, as the API leaves them.
+func (f *fakeController) existingNetwork(source string) {
+	f.t.Helper()
+	c, err := compileRules(source, nil)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	f.networks[fakeNetworkId] = &NetworkReadOnly{
+		Id:                 fakeNetworkId,
+		Config:             &ConfigReadOnly{Config: Config{Name: "existing"}, Rules: c.Rules, Capabilities: c.Capabilities, Tags: c.Tags},
+		CapabilitiesByName: c.CapabilitiesByName,
+		TagsByName:         c.TagsByName,
+	}
+}
@@ -0,0 +1,75 @@
+package zerotier
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+)
+
+// ZeroTier derives two kinds of IPv6 address from the network and node ids,
+// as in ZeroTier's InetAddress::makeIpv6rfc4193 and makeIpv66plane. They're
+// worked out here rather than read from Central, so they're known at plan.
+
+func parseZeroTierId(kind, id string, length int) ([]byte, error) {
+	b, err := hex.DecodeString(id)
+	if err != nil || len(b) != length {
+		return nil, fmt.Errorf("%s %q should be %d hex digits", kind, id, length*2)
+	}
+	return b, nil
+}
+
+// fd, the network id, 99 93, then the node id
+func rfc4193Address(nwid, nodeId string) (net.IP, error) {
+	network, err := parseZeroTierId("network id", nwid, 8)
+	if err != nil {
+		return nil, err
+	}
+	node, err := parseZeroTierId("node id", nodeId, 5)
+	if err != nil {
+		return nil, err
+	}
+	ip := make(net.IP, net.IPv6len)
+	ip[0] = 0xfd
+	copy(ip[1:9], network)
+	ip[9] = 0x99
+	ip[10] = 0x93
+	copy(ip[11:], node)
+	return ip, nil
+}
+
+func rfc4193Prefix(nwid string) (string, error) {
+	ip, err := rfc4193Address(nwid, "0000000000")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/88", ip), nil
+}
+
+// fc, the two halves of the network id xored together, the node id, then
+// zeros up to a final 01. Each member gets the /80 it's in.
+func sixplaneAddress(nwid, nodeId string) (net.IP, error) {
+	network, err := parseZeroTierId("network id", nwid, 8)
+	if err != nil {
+		return nil, err
+	}
+	node, err := parseZeroTierId("node id", nodeId, 5)
+	if err != nil {
+		return nil, err
+	}
+	ip := make(net.IP, net.IPv6len)
+	ip[0] = 0xfc
+	for i := 0; i < 4; i++ {
+		ip[1+i] = network[i] ^ network[4+i]
+	}
+	copy(ip[5:10], node)
+	ip[15] = 0x01
+	return ip, nil
+}
+
+func sixplanePrefix(nwid string) (string, error) {
+	ip, err := sixplaneAddress(nwid, "0000000000")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/40", ip.Mask(net.CIDRMask(40, 128))), nil
+}
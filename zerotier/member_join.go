@@ -0,0 +1,111 @@
+package zerotier
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// When node_id isn't known, a member can wait for a node matching the join
+// block to ask to join, and then authorize and configure it. The instance
+// only has to run `zerotier-cli join`, so the API key stays in Terraform.
+// The join block is only used to find the node when the member is created.
+
+func joinBlock() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"physical_address_cidr": {
+				Type:         schema.TypeString,
+				Required:     true,
+				ValidateFunc: validateJoinCIDR,
+				Description:  "Only a node connecting from an address in this range, eg the instance's public IP/32.",
+			},
+		},
+	}
+}
+
+// The shortest prefixes a join range can have. Anything wider would let in
+// nodes from a good part of the internet that happen to know the network id.
+const (
+	minJoinPrefixV4 = 16
+	minJoinPrefixV6 = 48
+)
+
+func validateJoinCIDR(i interface{}, k string) ([]string, []error) {
+	v, ok := i.(string)
+	if !ok {
+		return nil, []error{fmt.Errorf("expected type of %q to be string", k)}
+	}
+	_, ipnet, err := net.ParseCIDR(v)
+	if err != nil {
+		return nil, []error{fmt.Errorf("%q must be a CIDR, got %q: %s", k, v, err)}
+	}
+	ones, bits := ipnet.Mask.Size()
+	family, min := "IPv4", minJoinPrefixV4
+	if bits == 128 {
+		family, min = "IPv6", minJoinPrefixV6
+	}
+	if ones < min {
+		return nil, []error{fmt.Errorf("%q must be an %s range of /%d or narrower, so that not just any node can join, got %q", k, family, min, v)}
+	}
+	return nil, nil
+}
+
+// joinMatches is whether a member is a node waiting to join that fits the
+// join block.
+func joinMatches(m MemberReadOnly, criteria map[string]interface{}) bool {
+	if m.Config == nil || m.Config.Authorized {
+		return false
+	}
+	_, ipnet, err := net.ParseCIDR(criteria["physical_address_cidr"].(string))
+	if err != nil {
+		return false
+	}
+	// Central gives ip/port for some nodes
+	ip := net.ParseIP(strings.SplitN(m.PhysicalAddress, "/", 2)[0])
+	return ip != nil && ipnet.Contains(ip)
+}
+
+// waitForJoin polls the network's members until a node matching the join
+// block turns up, and returns its node id. If several match, the one that
+// asked first is taken.
+func waitForJoin(client *ZeroTierClient, d *schema.ResourceData, timeout time.Duration) (string, error) {
+	nwid := d.Get("network_id").(string)
+	join := d.Get("join").([]interface{})
+	if len(join) == 0 || join[0] == nil {
+		return "", fmt.Errorf("either node_id or a join block is required")
+	}
+	criteria := join[0].(map[string]interface{})
+
+	var nodeId string
+	err := resource.Retry(timeout, func() *resource.RetryError {
+		members, err := client.ListMembers(nwid)
+		if err != nil {
+			return resource.NonRetryableError(fmt.Errorf("unable to list members of network %s: %s", nwid, err))
+		}
+		var matches []MemberReadOnly
+		for _, m := range members {
+			if joinMatches(m, criteria) {
+				matches = append(matches, m)
+			}
+		}
+		if len(matches) == 0 {
+			return resource.RetryableError(fmt.Errorf("no node matching the join block has asked to join network %s yet", nwid))
+		}
+		sort.Slice(matches, func(i, j int) bool { return matches[i].Config.CreationTime < matches[j].Config.CreationTime })
+		nodeId = matches[0].NodeId
+		return nil
+	})
+	return nodeId, err
+}
+
+// joinNodeIdDiffSuppress keeps the node id found by joining, which isn't in
+// the config.
+func joinNodeIdDiffSuppress(k, old, new string, d *schema.ResourceData) bool {
+	return new == "" && len(d.Get("join").([]interface{})) > 0
+}
@@ -0,0 +1,96 @@
+package zerotier
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// checkAddressing returns an error explaining any assignment pools and routes
+// that contradict each other: pools that overlap, pools outside every route,
+// and gateways (via) that members can't reach because they aren't inside a
+// route on the network itself.
+func checkAddressing(pools []IpRange, routes []Route) error {
+	var problems []string
+
+	type parsedRoute struct {
+		Route
+		target *net.IPNet
+	}
+	var parsed []parsedRoute
+	for _, r := range routes {
+		if _, target, err := net.ParseCIDR(r.Target); err == nil {
+			parsed = append(parsed, parsedRoute{r, target})
+		}
+	}
+
+	for i, p := range pools {
+		first, last := net.ParseIP(p.First), net.ParseIP(p.Last)
+		for _, other := range pools[i+1:] {
+			otherFirst, otherLast := net.ParseIP(other.First), net.ParseIP(other.Last)
+			if (first.To4() == nil) != (otherFirst.To4() == nil) {
+				continue
+			}
+			if bytes.Compare(first.To16(), otherLast.To16()) <= 0 && bytes.Compare(otherFirst.To16(), last.To16()) <= 0 {
+				problems = append(problems, fmt.Sprintf("assignment pools %s-%s and %s-%s overlap, so members could be given the same address", p.First, p.Last, other.First, other.Last))
+			}
+		}
+
+		routed := false
+		for _, r := range parsed {
+			if r.target.Contains(first) && r.target.Contains(last) {
+				routed = true
+			}
+		}
+		if !routed {
+			problems = append(problems, fmt.Sprintf("assignment pool %s-%s isn't inside any route, so members given its addresses can't reach each other; add a route for %s", p.First, p.Last, SmallestCIDR(first, last)))
+		}
+	}
+
+	for _, r := range parsed {
+		if r.Via == nil {
+			continue
+		}
+		via := net.ParseIP(*r.Via)
+		if via == nil {
+			continue
+		}
+		reachable := false
+		for _, lan := range parsed {
+			if lan.Via == nil && lan.target.Contains(via) {
+				reachable = true
+			}
+		}
+		if !reachable {
+			problems = append(problems, fmt.Sprintf("route to %s is via %s, which isn't inside any route without a via, so members have no way to reach the gateway", r.Target, *r.Via))
+		}
+		if r.target.Contains(via) {
+			problems = append(problems, fmt.Sprintf("route to %s is via %s, which is inside the route itself", r.Target, *r.Via))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("assignment pools and routes don't add up:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// addressingCustomizeDiff checks the planned pools and routes together. Pools
+// and routes that aren't known until apply are left to Central.
+func addressingCustomizeDiff(d *schema.ResourceDiff) error {
+	if !d.NewValueKnown("assignment_pool") || !d.NewValueKnown("route") {
+		return nil
+	}
+	var pools []IpRange
+	for _, raw := range d.Get("assignment_pool").(*schema.Set).List() {
+		first, last, err := poolRange(raw.(map[string]interface{}))
+		if err != nil {
+			return nil
+		}
+		pools = append(pools, IpRange{First: first.String(), Last: last.String()})
+	}
+	return checkAddressing(pools, expandRoutes(d.Get("route").(*schema.Set)))
+}
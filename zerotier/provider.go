@@ -51,8 +51,12 @@ func Provider() terraform.ResourceProvider {
 			},
 		},
 		ResourcesMap: map[string]*schema.Resource{
-			"zerotier_network": resourceZeroTierNetwork(),
-			"zerotier_member":  resourceZeroTierMember(),
+			"zerotier_network":       resourceZeroTierNetwork(),
+			"zerotier_network_rules": resourceZeroTierNetworkRules(),
+			"zerotier_member":        resourceZeroTierMember(),
+		},
+		DataSourcesMap: map[string]*schema.Resource{
+			"zerotier_rules_evaluation": dataSourceZeroTierRulesEvaluation(),
 		},
 		ConfigureFunc: configureProvider,
 	}
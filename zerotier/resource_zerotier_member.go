@@ -4,20 +4,29 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/hashicorp/terraform/helper/resource"
 	"github.com/hashicorp/terraform/helper/schema"
 )
 
 func resourceZeroTierMember() *schema.Resource {
 	return &schema.Resource{
-		Create: resourceMemberCreate,
-		Read:   resourceMemberRead,
-		Update: resourceMemberUpdate,
-		Delete: resourceMemberDelete,
-		Exists: resourceMemberExists,
+		Create:        resourceMemberCreate,
+		Read:          resourceMemberRead,
+		Update:        resourceMemberUpdate,
+		Delete:        resourceMemberDelete,
+		Exists:        resourceMemberExists,
+		CustomizeDiff: resourceMemberCustomizeDiff,
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(10 * time.Minute),
+			Update: schema.DefaultTimeout(10 * time.Minute),
+		},
 		Importer: &schema.ResourceImporter{
 			State: schema.ImportStatePassthrough,
 		},
+		SchemaVersion: 1,
+		MigrateState:  resourceMemberMigrateState,
 
 		Schema: map[string]*schema.Schema{
 			"network_id": {
@@ -26,9 +35,19 @@ func resourceZeroTierMember() *schema.Resource {
 				ForceNew: true,
 			},
 			"node_id": {
-				Type:     schema.TypeString,
-				Required: true,
-				ForceNew: true,
+				Type:             schema.TypeString,
+				Optional:         true,
+				ForceNew:         true,
+				DiffSuppressFunc: joinNodeIdDiffSuppress,
+				Description:      "Leave out, with a join block, to take on the first node matching it that asks to join.",
+			},
+			"join": {
+				Type:          schema.TypeList,
+				Optional:      true,
+				MaxItems:      1,
+				ConflictsWith: []string{"node_id"},
+				Description:   "Without node_id, wait for a node matching these to ask to join, then authorize and configure it. Only used on create.",
+				Elem:          joinBlock(),
 			},
 			"name": {
 				Type:     schema.TypeString,
@@ -64,6 +83,68 @@ func resourceZeroTierMember() *schema.Resource {
 				Optional: true,
 				Default:  false,
 			},
+			"wait_for_online": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Don't finish creating the member, or authorizing it, until it's online, or the create/update timeout runs out.",
+			},
+			"wait_for_ips": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Don't finish creating the member, or authorizing it, until it has been assigned IP addresses. With wait_for_online, wait for both.",
+			},
+			"sso_exempt": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Let this member on a network that requires SSO without logging in, eg for servers.",
+			},
+			"authentication_expiry_time": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "When the member has to log in with SSO again, in milliseconds since the epoch. 0 if it hasn't logged in. A string, as it doesn't fit an int on 32-bit platforms.",
+			},
+			"rfc4193_address": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The member's RFC4193 address, which it has when the network has assign_rfc4193 on.",
+			},
+			"sixplane_address": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The member's 6PLANE address, which it has when the network has assign_6plane on.",
+			},
+			"online": {
+				Type:        schema.TypeBool,
+				Computed:    true,
+				Description: "Whether the member was online at the last refresh.",
+			},
+			"last_online": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "When the member was last online, in milliseconds since the epoch. A string, as it doesn't fit an int on 32-bit platforms.",
+			},
+			"last_authorized_time": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "When the member was last authorized, in milliseconds since the epoch. A string, as it doesn't fit an int on 32-bit platforms.",
+			},
+			"physical_address": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The public IP address the member last connected from.",
+			},
+			"client_version": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Version of ZeroTier the member runs, eg 1.6.2.",
+			},
+			"protocol_version": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
 			"ip_assignments": {
 				Type:     schema.TypeList,
 				Optional: true,
@@ -72,36 +153,72 @@ func resourceZeroTierMember() *schema.Resource {
 				},
 			},
 			"capabilities": {
-				Type:     schema.TypeList,
-				Optional: true,
+				Type:             schema.TypeList,
+				Optional:         true,
+				MaxItems:         maxNetworkCapabilities,
+				DiffSuppressFunc: memberNamesDiffSuppress,
 				Elem: &schema.Schema{
 					Type: schema.TypeInt,
 				},
 			},
 			"tags": {
-				Type:     schema.TypeMap,
-				Optional: true,
+				Type:             schema.TypeMap,
+				Optional:         true,
+				ValidateFunc:     validateTagIds,
+				DiffSuppressFunc: memberNamesDiffSuppress,
 				Elem: &schema.Schema{
 					Type: schema.TypeInt,
 				},
 			},
+			"capability_names": {
+				Type:          schema.TypeList,
+				Optional:      true,
+				MaxItems:      maxNetworkCapabilities,
+				ConflictsWith: []string{"capabilities", "tags"},
+				Description:   "Capabilities by name (or id), looked up in the network's rules.",
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"tag_values": {
+				Type:          schema.TypeMap,
+				Optional:      true,
+				ValidateFunc:  validateMemberTags,
+				ConflictsWith: []string{"capabilities", "tags"},
+				Description:   "Tags by name (or id), to a value or enum or flag name, looked up in the network's rules.",
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
 		},
 	}
 }
 
 func resourceMemberCreate(d *schema.ResourceData, m interface{}) error {
 	client := m.(*ZeroTierClient)
+	if d.Get("node_id").(string) == "" {
+		nodeId, err := waitForJoin(client, d, d.Timeout(schema.TimeoutCreate))
+		if err != nil {
+			return err
+		}
+		d.Set("node_id", nodeId)
+	}
 	stored, err := memberFromResourceData(d)
 	if err != nil {
 		return err
 	}
+	if err := resolveMemberRules(client, d, stored); err != nil {
+		return err
+	}
 	created, err := client.CreateMember(stored)
 	if err != nil {
 		return err
 	}
 	d.SetId(created.Id)
+	setMemberAddresses(d, stored.NetworkId, stored.NodeId)
 	setTags(d, created)
-	return nil
+	setMemberStatus(d, created)
+	return waitForMember(client, d, created, d.Timeout(schema.TimeoutCreate))
 }
 
 func resourceMemberUpdate(d *schema.ResourceData, m interface{}) error {
@@ -110,21 +227,96 @@ func resourceMemberUpdate(d *schema.ResourceData, m interface{}) error {
 	if err != nil {
 		return err
 	}
+	if err := resolveMemberRules(client, d, stored); err != nil {
+		return err
+	}
 	updated, err := client.UpdateMember(stored)
 	if err != nil {
 		return fmt.Errorf("unable to update member using ZeroTier API: %s", err)
 	}
 	setTags(d, updated)
-	return nil
+	setMemberStatus(d, updated)
+	// only authorizing the member brings it onto the network; a new node_id
+	// replaces the member, and waits in create
+	if !d.HasChange("authorized") || !d.Get("authorized").(bool) {
+		return nil
+	}
+	return waitForMember(client, d, updated, d.Timeout(schema.TimeoutUpdate))
 }
 
-func setTags(d *schema.ResourceData, member *Member) {
+func setTags(d *schema.ResourceData, member *MemberReadOnly) {
 	rawTags := map[string]int{}
 	for _, tuple := range member.Config.Tags {
 		key := fmt.Sprintf("%d", tuple[0])
 		val := tuple[1]
 		rawTags[key] = val
 	}
+	d.Set("tags", rawTags)
+}
+
+// waitForMember polls the member until it's online if wait_for_online is set,
+// and has IP addresses if wait_for_ips is set, so that anything depending on
+// it can reach it.
+func waitForMember(client *ZeroTierClient, d *schema.ResourceData, member *MemberReadOnly, timeout time.Duration) error {
+	waitForOnline := d.Get("wait_for_online").(bool)
+	waitForIps := d.Get("wait_for_ips").(bool)
+	if !waitForOnline && !waitForIps {
+		return nil
+	}
+	ready := func(m *MemberReadOnly) bool {
+		return (!waitForOnline || m.Online) && (!waitForIps || len(m.Config.IpAssignments) > 0)
+	}
+	if ready(member) {
+		return nil
+	}
+	return resource.Retry(timeout, func() *resource.RetryError {
+		m, err := client.GetMember(member.NetworkId, member.NodeId)
+		if err != nil {
+			return resource.NonRetryableError(fmt.Errorf("unable to check whether member %s is online: %s", member.NodeId, err))
+		}
+		setMemberStatus(d, m)
+		if !ready(m) {
+			if waitForOnline && !m.Online {
+				return resource.RetryableError(fmt.Errorf("member %s isn't online yet", member.NodeId))
+			}
+			return resource.RetryableError(fmt.Errorf("member %s has no IP addresses yet", member.NodeId))
+		}
+		return nil
+	})
+}
+
+// setMemberStatus sets the attributes the member reports to Central.
+func setMemberStatus(d *schema.ResourceData, member *MemberReadOnly) {
+	d.Set("online", member.Online)
+	d.Set("last_online", strconv.FormatInt(member.LastOnline, 10))
+	d.Set("last_authorized_time", strconv.FormatInt(member.Config.LastAuthorizedTime, 10))
+	d.Set("physical_address", member.PhysicalAddress)
+	clientVersion := member.ClientVersion
+	// older controllers only give the parts, which are -1 until it's been seen
+	if clientVersion == "" && member.Config.VMajor >= 0 && member.Config.VMinor >= 0 && member.Config.VRev >= 0 && member.Config.VMajor+member.Config.VMinor+member.Config.VRev > 0 {
+		clientVersion = fmt.Sprintf("%d.%d.%d", member.Config.VMajor, member.Config.VMinor, member.Config.VRev)
+	}
+	d.Set("client_version", clientVersion)
+	protocolVersion := member.ProtocolVersion
+	if protocolVersion == 0 && member.Config.VProto > 0 {
+		protocolVersion = member.Config.VProto
+	}
+	d.Set("protocol_version", protocolVersion)
+}
+
+// validateTagIds checks that tags are keyed by tag id. Use tag_values to give
+// tags by name.
+func validateTagIds(i interface{}, k string) ([]string, []error) {
+	warnings, errs := validateMemberTags(i, k)
+	if len(errs) > 0 {
+		return warnings, errs
+	}
+	for key := range i.(map[string]interface{}) {
+		if _, err := strconv.Atoi(key); err != nil {
+			errs = append(errs, fmt.Errorf("%q keys must be tag ids, got %q; use tag_values for tag names", k, key))
+		}
+	}
+	return warnings, errs
 }
 
 func resourceMemberDelete(d *schema.ResourceData, m interface{}) error {
@@ -143,7 +335,7 @@ func memberFromResourceData(d *schema.ResourceData) (*Member, error) {
 	for key, val := range tags {
 		i, err := strconv.Atoi(key)
 		if err != nil {
-			break
+			return nil, fmt.Errorf("tags: %q is not a tag id", key)
 		}
 		tagTuples = append(tagTuples, []int{i, val.(int)})
 	}
@@ -172,6 +364,7 @@ func memberFromResourceData(d *schema.ResourceData) (*Member, error) {
 			Capabilities:    caps,
 			Tags:            tagTuples,
 			IpAssignments:   ips,
+			SSOExempt:       d.Get("sso_exempt").(bool),
 		},
 	}
 	return n, nil
@@ -221,12 +414,60 @@ func resourceMemberRead(d *schema.ResourceData, m interface{}) error {
 	d.Set("allow_ethernet_bridging", member.Config.ActiveBridge)
 	d.Set("no_auto_assign_ips", member.Config.NoAutoAssignIps)
 	d.Set("ip_assignments", member.Config.IpAssignments)
+	d.Set("sso_exempt", member.Config.SSOExempt)
+	setMemberAddresses(d, nwid, nodeId)
+	setMemberStatus(d, member)
+	d.Set("authentication_expiry_time", strconv.FormatInt(member.Config.AuthenticationExpiryTime, 10))
 	d.Set("capabilities", member.Config.Capabilities)
 	setTags(d, member)
 
+	if usesMemberNames(d) {
+		n, err := client.GetNetwork(nwid)
+		if err != nil {
+			return fmt.Errorf("unable to read network %s to name capabilities and tags: %s", nwid, err)
+		}
+		setCapabilityNames(d, n, member)
+		setTagValues(d, n, member)
+	}
+
+	return nil
+}
+
+// The derived addresses only depend on the network and node ids, so they can
+// be known at plan.
+func resourceMemberCustomizeDiff(d *schema.ResourceDiff, m interface{}) error {
+	if !d.NewValueKnown("node_id") {
+		return nil
+	}
+	if d.Get("node_id").(string) == "" && len(d.Get("join").([]interface{})) == 0 {
+		return fmt.Errorf("either node_id or a join block is required")
+	}
+	if !d.NewValueKnown("network_id") {
+		return nil
+	}
+	nwid, nodeId := d.Get("network_id").(string), d.Get("node_id").(string)
+	if ip, err := rfc4193Address(nwid, nodeId); err == nil && d.Get("rfc4193_address").(string) != ip.String() {
+		if err := d.SetNew("rfc4193_address", ip.String()); err != nil {
+			return err
+		}
+	}
+	if ip, err := sixplaneAddress(nwid, nodeId); err == nil && d.Get("sixplane_address").(string) != ip.String() {
+		if err := d.SetNew("sixplane_address", ip.String()); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+func setMemberAddresses(d *schema.ResourceData, nwid, nodeId string) {
+	if ip, err := rfc4193Address(nwid, nodeId); err == nil {
+		d.Set("rfc4193_address", ip.String())
+	}
+	if ip, err := sixplaneAddress(nwid, nodeId); err == nil {
+		d.Set("sixplane_address", ip.String())
+	}
+}
+
 func resourceMemberExists(d *schema.ResourceData, m interface{}) (b bool, e error) {
 	client := m.(*ZeroTierClient)
 	nwid, nodeId := resourceNetworkAndNodeIdentifiers(d)
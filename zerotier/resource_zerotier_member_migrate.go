@@ -0,0 +1,49 @@
+package zerotier
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func resourceMemberMigrateState(v int, is *terraform.InstanceState, meta interface{}) (*terraform.InstanceState, error) {
+	switch v {
+	case 0:
+		log.Println("[INFO] Found ZeroTier member state v0; migrating to v1")
+		return migrateMemberStateV0toV1(is)
+	default:
+		return is, fmt.Errorf("unexpected schema version: %d", v)
+	}
+}
+
+// Before v1, tags were never read back, and tags with keys that weren't ids
+// were kept in state without being sent. Drop those, so that the first
+// refresh only shows tags that really differ.
+func migrateMemberStateV0toV1(is *terraform.InstanceState) (*terraform.InstanceState, error) {
+	if is.Empty() || is.Attributes == nil {
+		log.Println("[DEBUG] Empty ZeroTier member state; nothing to migrate.")
+		return is, nil
+	}
+	log.Printf("[DEBUG] Attributes before migration: %#v", is.Attributes)
+
+	count := 0
+	for k := range is.Attributes {
+		if !strings.HasPrefix(k, "tags.") || k == "tags.%" {
+			continue
+		}
+		if _, err := strconv.Atoi(strings.TrimPrefix(k, "tags.")); err != nil {
+			delete(is.Attributes, k)
+			continue
+		}
+		count++
+	}
+	if _, ok := is.Attributes["tags.%"]; ok {
+		is.Attributes["tags.%"] = strconv.Itoa(count)
+	}
+
+	log.Printf("[DEBUG] Attributes after migration: %#v", is.Attributes)
+	return is, nil
+}
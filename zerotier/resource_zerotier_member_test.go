@@ -0,0 +1,231 @@
+package zerotier
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/config"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestMemberSSOExempt(t *testing.T) {
+	f := newFakeController(t)
+	defer f.Close()
+	f.networks[fakeNetworkId] = &NetworkReadOnly{Id: fakeNetworkId, Config: &ConfigReadOnly{}}
+	r := resourceZeroTierMember()
+	path := "/network/" + fakeNetworkId + "/member/a1b2c3d4e5"
+	raw := map[string]interface{}{
+		"network_id": fakeNetworkId,
+		"node_id":    "a1b2c3d4e5",
+		"sso_exempt": true,
+	}
+
+	var state *terraform.InstanceState
+	state = f.apply(r, state, raw)
+	var posted struct {
+		Config map[string]interface{} `json:"config"`
+	}
+	f.lastPosted(path, &posted)
+	if posted.Config["ssoExempt"] != true {
+		t.Errorf("POSTed config.ssoExempt %v, want true", posted.Config["ssoExempt"])
+	}
+	if state.Attributes["sso_exempt"] != "true" {
+		t.Errorf("sso_exempt is %q in state, want true", state.Attributes["sso_exempt"])
+	}
+
+	// Central sets when the member has to log in again
+	f.members[fakeNetworkId+"-a1b2c3d4e5"].Config.AuthenticationExpiryTime = 1700000000000
+	state, err := r.Refresh(state, f.client())
+	if err != nil {
+		t.Fatalf("refresh: %s", err)
+	}
+	if state.Attributes["authentication_expiry_time"] != "1700000000000" {
+		t.Errorf("authentication_expiry_time is %q in state, want 1700000000000", state.Attributes["authentication_expiry_time"])
+	}
+
+	raw["sso_exempt"] = false
+	state = f.apply(r, state, raw)
+	f.lastPosted(path, &posted)
+	if posted.Config["ssoExempt"] != false {
+		t.Errorf("POSTed config.ssoExempt %v, want false", posted.Config["ssoExempt"])
+	}
+	if state.Attributes["sso_exempt"] != "false" {
+		t.Errorf("sso_exempt is %q in state, want false", state.Attributes["sso_exempt"])
+	}
+}
+
+func TestMemberJoin(t *testing.T) {
+	f := newFakeController(t)
+	defer f.Close()
+	f.networks[fakeNetworkId] = &NetworkReadOnly{Id: fakeNetworkId, Config: &ConfigReadOnly{}}
+	pending := func(nodeId, physicalAddress string, creationTime int64) *MemberReadOnly {
+		m := &MemberReadOnly{PhysicalAddress: physicalAddress}
+		m.Id, m.NetworkId, m.NodeId = fakeNetworkId+"-"+nodeId, fakeNetworkId, nodeId
+		m.Config = &MemberConfigReadOnly{CreationTime: creationTime}
+		return m
+	}
+	f.members[fakeNetworkId+"-1111111111"] = pending("1111111111", "198.51.100.7/9993", 1)
+	f.members[fakeNetworkId+"-2222222222"] = pending("2222222222", "203.0.113.9/9993", 2)
+	f.members[fakeNetworkId+"-3333333333"] = pending("3333333333", "203.0.113.10/41641", 3)
+
+	r := resourceZeroTierMember()
+	raw := map[string]interface{}{
+		"network_id": fakeNetworkId,
+		"join": []interface{}{map[string]interface{}{
+			"physical_address_cidr": "203.0.113.0/24",
+		}},
+	}
+	state := f.apply(r, nil, raw)
+	if state.Attributes["node_id"] != "2222222222" {
+		t.Errorf("joined %q, want the first node to ask from 203.0.113.0/24, 2222222222", state.Attributes["node_id"])
+	}
+	if !f.members[fakeNetworkId+"-2222222222"].Config.Authorized {
+		t.Errorf("the joining node wasn't authorized")
+	}
+	if f.members[fakeNetworkId+"-3333333333"].Config.Authorized {
+		t.Errorf("a second matching node was authorized")
+	}
+
+	// the node id found isn't in config, but mustn't replace the member
+	c, err := config.NewRawConfig(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff, err := r.Diff(state, terraform.NewResourceConfig(c), f.client())
+	if err != nil {
+		t.Fatalf("plan: %s", err)
+	}
+	if !diff.Empty() {
+		t.Errorf("plan after joining isn't empty: %v", diff)
+	}
+}
+
+func TestMemberJoinPlan(t *testing.T) {
+	r := resourceZeroTierMember()
+	cases := []struct {
+		name string
+		raw  map[string]interface{}
+		err  string
+	}{
+		{
+			name: "neither node_id nor join",
+			raw:  map[string]interface{}{"network_id": fakeNetworkId},
+			err:  "either node_id or a join block is required",
+		},
+		{
+			name: "both node_id and join",
+			raw: map[string]interface{}{
+				"network_id": fakeNetworkId,
+				"node_id":    "a1b2c3d4e5",
+				"join":       []interface{}{map[string]interface{}{"physical_address_cidr": "203.0.113.9/32"}},
+			},
+			err: "conflicts with node_id",
+		},
+		{
+			name: "any IPv4 address",
+			raw: map[string]interface{}{
+				"network_id": fakeNetworkId,
+				"join":       []interface{}{map[string]interface{}{"physical_address_cidr": "0.0.0.0/0"}},
+			},
+			err: "/16 or narrower",
+		},
+		{
+			name: "any IPv6 address",
+			raw: map[string]interface{}{
+				"network_id": fakeNetworkId,
+				"join":       []interface{}{map[string]interface{}{"physical_address_cidr": "::/0"}},
+			},
+			err: "/48 or narrower",
+		},
+		{
+			name: "an instance's address",
+			raw: map[string]interface{}{
+				"network_id": fakeNetworkId,
+				"join":       []interface{}{map[string]interface{}{"physical_address_cidr": "2001:db8::1/128"}},
+			},
+		},
+	}
+	for _, c := range cases {
+		rc, err := config.NewRawConfig(c.raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conf := terraform.NewResourceConfig(rc)
+		var errs []error
+		if _, errs = r.Validate(conf); len(errs) == 0 {
+			if _, err := r.Diff(nil, conf, nil); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		switch {
+		case c.err == "" && len(errs) > 0:
+			t.Errorf("%s: unexpected errors %v", c.name, errs)
+		case c.err != "" && (len(errs) == 0 || !strings.Contains(fmt.Sprint(errs), c.err)):
+			t.Errorf("%s: got errors %v, want one containing %q", c.name, errs, c.err)
+		}
+	}
+}
+
+// Times are in milliseconds, which don't fit an int on 32-bit platforms.
+func TestMemberTimes(t *testing.T) {
+	f := newFakeController(t)
+	defer f.Close()
+	f.networks[fakeNetworkId] = &NetworkReadOnly{Id: fakeNetworkId, Config: &ConfigReadOnly{}}
+	r := resourceZeroTierMember()
+	state := f.apply(r, nil, map[string]interface{}{
+		"network_id": fakeNetworkId,
+		"node_id":    "a1b2c3d4e5",
+	})
+
+	m := f.members[fakeNetworkId+"-a1b2c3d4e5"]
+	m.LastOnline = 1700000123456
+	m.Config.LastAuthorizedTime = 1700000000000
+	state, err := r.Refresh(state, f.client())
+	if err != nil {
+		t.Fatalf("refresh: %s", err)
+	}
+	if state.Attributes["last_online"] != "1700000123456" || state.Attributes["last_authorized_time"] != "1700000000000" {
+		t.Errorf("last_online %q, last_authorized_time %q", state.Attributes["last_online"], state.Attributes["last_authorized_time"])
+	}
+}
+
+func TestMemberWait(t *testing.T) {
+	f := newFakeController(t)
+	defer f.Close()
+	f.networks[fakeNetworkId] = &NetworkReadOnly{Id: fakeNetworkId, Config: &ConfigReadOnly{}}
+	r := resourceZeroTierMember()
+	// the fake member never comes online, so any wait for it times out
+	raw := map[string]interface{}{
+		"network_id":     fakeNetworkId,
+		"node_id":        "a1b2c3d4e5",
+		"ip_assignments": []interface{}{"10.0.0.5"},
+		"wait_for_ips":   true,
+		"timeouts":       []map[string]interface{}{{"create": "1s", "update": "1s"}},
+	}
+
+	// wait_for_ips waits on its own, and the member already has an address
+	state := f.apply(r, nil, raw)
+
+	// updates that don't authorize the member don't wait for it
+	raw["wait_for_online"] = true
+	raw["allow_ethernet_bridging"] = true
+	state = f.apply(r, state, raw)
+	raw["authorized"] = false
+	state = f.apply(r, state, raw)
+
+	// authorizing it again does
+	raw["authorized"] = true
+	c, err := config.NewRawConfig(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff, err := r.Diff(state, terraform.NewResourceConfig(c), f.client())
+	if err != nil {
+		t.Fatalf("plan: %s", err)
+	}
+	_, err = r.Apply(state, diff, f.client())
+	if err == nil || !strings.Contains(err.Error(), "member a1b2c3d4e5 isn't online yet") {
+		t.Errorf("authorizing an offline member: got %v, want it to wait for the member to come online", err)
+	}
+}
@@ -4,10 +4,14 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net"
+	"regexp"
+	"strconv"
 
 	"github.com/hashicorp/terraform/helper/hashcode"
 	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
 )
 
 func route() *schema.Resource {
@@ -17,23 +21,26 @@ func route() *schema.Resource {
 				Type:             schema.TypeString,
 				Required:         true,
 				DiffSuppressFunc: diffSuppress,
+				ValidateFunc:     validation.CIDRNetwork(0, 128),
 			},
 			"via": &schema.Schema{
 				Type:             schema.TypeString,
 				Optional:         true,
 				DiffSuppressFunc: diffSuppress,
+				ValidateFunc:     validation.SingleIP(),
 			},
 		},
 	}
 }
 
 func resourceZeroTierNetwork() *schema.Resource {
-	return &schema.Resource{
-		Create: resourceNetworkCreate,
-		Read:   resourceNetworkRead,
-		Update: resourceNetworkUpdate,
-		Delete: resourceNetworkDelete,
-		Exists: resourceNetworkExists,
+	r := &schema.Resource{
+		Create:        resourceNetworkCreate,
+		Read:          resourceNetworkRead,
+		Update:        resourceNetworkUpdate,
+		Delete:        resourceNetworkDelete,
+		Exists:        resourceNetworkExists,
+		CustomizeDiff: resourceNetworkCustomizeDiff,
 		Importer: &schema.ResourceImporter{
 			State: schema.ImportStatePassthrough,
 		},
@@ -48,12 +55,11 @@ func resourceZeroTierNetwork() *schema.Resource {
 				Optional: true,
 				Default:  "Managed by Terraform",
 			},
-			"rules_source": &schema.Schema{
-				Type:     schema.TypeString,
-				Optional: true,
-				// pulled from ZT's default
-				Default: "#\n# Allow only IPv4, IPv4 ARP, and IPv6 Ethernet frames.\n#\ndrop\n\tnot ethertype ipv4\n\tand not ethertype arp\n\tand not ethertype ipv6\n;\n\n#\n# Uncomment to drop non-ZeroTier issued and managed IP addresses.\n#\n# This prevents IP spoofing but also blocks manual IP management at the OS level and\n# bridging unless special rules to exempt certain hosts or traffic are added before\n# this rule.\n#\n#drop\n#\tnot chr ipauth\n#;\n\n# Accept anything else. This is required since default is 'drop'.\naccept;",
-				Set:     stringHash,
+			"manage_rules": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     true,
+				Description: "Set to false when the rules are managed by a zerotier_network_rules resource, so this one leaves them alone.",
 			},
 			"private": &schema.Schema{
 				Type:     schema.TypeBool,
@@ -65,10 +71,150 @@ func resourceZeroTierNetwork() *schema.Resource {
 				Optional: true,
 				Default:  true,
 			},
+			"auto_assign_v6": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Assign IPv6 addresses to members from the IPv6 assignment pools.",
+			},
+			"assign_rfc4193": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Give each member an RFC4193 (fd00::/8) address derived from the network and node ids.",
+			},
+			"assign_6plane": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Give each member a 6PLANE (fc00::/8) address, and a /80 to route for containers or VMs.",
+			},
+			"multicast_limit": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      32,
+				ValidateFunc: validation.IntBetween(0, 65535),
+				Description:  "Maximum number of members a multicast (eg ARP or service discovery) is sent to. 0 turns multicast off.",
+			},
+			"enable_broadcast": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     true,
+				Description: "Whether members get Ethernet broadcasts (ff:ff:ff:ff:ff:ff).",
+			},
+			"mtu": &schema.Schema{
+				Type:     schema.TypeInt,
+				Optional: true,
+				Default:  2800,
+				// ZT_MIN_MTU and ZT_MAX_MTU
+				ValidateFunc: validation.IntBetween(1280, 10000),
+				Description:  "MTU of the network's virtual interfaces.",
+			},
+			"remote_trace_target": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.StringMatch(regexp.MustCompile("^[0-9a-f]{10}$"), "must be a 10 digit hex ZeroTier node id"),
+				Description:  "Node id (10 hex digits) that members send trace events to. Leave empty to turn tracing off.",
+			},
+			"remote_trace_level": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      0,
+				ValidateFunc: validation.IntInSlice([]int{0, 10, 15, 20, 30}),
+				Description:  "How much members send to remote_trace_target: 0 normal, 10 verbose, 15 rules (every rule evaluated), 20 debug, 30 insane.",
+			},
+			"sso": &schema.Schema{
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "Require members to log in with OIDC. Members with sso_exempt don't have to.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"issuer": &schema.Schema{
+							Type:         schema.TypeString,
+							Required:     true,
+							ValidateFunc: validation.StringMatch(regexp.MustCompile("^https://"), "must be an https:// URL"),
+						},
+						"client_id": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"authorization_endpoint": &schema.Schema{
+							Type:         schema.TypeString,
+							Optional:     true,
+							Computed:     true,
+							ValidateFunc: validation.StringMatch(regexp.MustCompile("^https://"), "must be an https:// URL"),
+							Description:  "Discovered from the issuer if not given.",
+						},
+					},
+				},
+			},
 			"route": &schema.Schema{
-				Type:     schema.TypeList,
+				Type:     schema.TypeSet,
 				Optional: true,
 				Elem:     route(),
+				Set:      resourceNetworkRouteHash,
+			},
+			"dns": &schema.Schema{
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "Search domain and DNS servers pushed to members.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"domain": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"servers": &schema.Schema{
+							Type:     schema.TypeList,
+							Optional: true,
+							// ZT_MAX_DNS_SERVERS
+							MaxItems: 4,
+							Elem: &schema.Schema{
+								Type:         schema.TypeString,
+								ValidateFunc: validation.SingleIP(),
+							},
+						},
+					},
+				},
+			},
+			"rfc4193_prefix": &schema.Schema{
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The /88 members' RFC4193 addresses are in, when assign_rfc4193 is on.",
+			},
+			"sixplane_prefix": &schema.Schema{
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The /40 members' 6PLANE addresses are in, when assign_6plane is on.",
+			},
+			"creation_time": &schema.Schema{
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "In milliseconds since the epoch, as a string so it fits on 32-bit platforms.",
+			},
+			"last_modified": &schema.Schema{
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "In milliseconds since the epoch, as a string so it fits on 32-bit platforms.",
+			},
+			"revision": &schema.Schema{
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Goes up by one every time the network's config changes.",
+			},
+			"online_member_count": &schema.Schema{
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+			"authorized_member_count": &schema.Schema{
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+			"total_member_count": &schema.Schema{
+				Type:     schema.TypeInt,
+				Computed: true,
 			},
 			"assignment_pool": &schema.Schema{
 				Type:     schema.TypeSet,
@@ -78,16 +224,19 @@ func resourceZeroTierNetwork() *schema.Resource {
 						"cidr": &schema.Schema{
 							Type:          schema.TypeString,
 							Optional:      true,
+							Computed:      true,
 							ConflictsWith: []string{"assignment_pool.first", "assignment_pool.last"},
 						},
 						"first": &schema.Schema{
 							Type:          schema.TypeString,
 							Optional:      true,
+							Computed:      true,
 							ConflictsWith: []string{"assignment_pool.cidr"},
 						},
 						"last": &schema.Schema{
 							Type:          schema.TypeString,
 							Optional:      true,
+							Computed:      true,
 							ConflictsWith: []string{"assignment_pool.cidr"},
 						},
 					},
@@ -96,12 +245,66 @@ func resourceZeroTierNetwork() *schema.Resource {
 			},
 		},
 	}
+	for k, v := range rulesSchema() {
+		r.Schema[k] = v
+	}
+	r.Schema["rules_source"].DiffSuppressFunc = networkRulesSourceDiffSuppress
+	return r
 }
 
 func diffSuppress(k, old, new string, d *schema.ResourceData) bool {
 	return old == new
 }
 
+// Central normalises whitespace and line endings in rulesSource, so only
+// plan a change when the rules would actually compile differently.
+func rulesSourceDiffSuppress(k, old, new string, d *schema.ResourceData) bool {
+	// the rule blocks drive the source, and have diffs of their own
+	if usesRuleBlocks(d) {
+		return true
+	}
+	return rulesSourceEquivalent(old, new, rulesIncludes(d))
+}
+
+// With manage_rules off, rules_source is left at its default in config, and
+// whatever zerotier_network_rules set is none of our business.
+func networkRulesSourceDiffSuppress(k, old, new string, d *schema.ResourceData) bool {
+	if !d.Get("manage_rules").(bool) {
+		return true
+	}
+	return rulesSourceDiffSuppress(k, old, new, d)
+}
+
+func resourceNetworkCustomizeDiff(d *schema.ResourceDiff, m interface{}) error {
+	if d.Get("manage_rules").(bool) {
+		if err := rulesCustomizeDiff(d, m); err != nil {
+			return err
+		}
+	} else if err := checkUnmanagedRules(d); err != nil {
+		return err
+	}
+	return addressingCustomizeDiff(d)
+}
+
+// With manage_rules off the rules arguments would be ignored, so setting them
+// is an error rather than a silent no-op.
+func checkUnmanagedRules(d *schema.ResourceDiff) error {
+	for _, k := range []string{"rules_includes", "rule", "capability", "tag"} {
+		if _, ok := d.GetOk(k); ok {
+			return fmt.Errorf("%s can't be set when manage_rules is false", k)
+		}
+	}
+	return nil
+}
+
+func rulesIncludes(d resourceGetter) map[string]string {
+	includes := map[string]string{}
+	for name, snippet := range d.Get("rules_includes").(map[string]interface{}) {
+		includes[name] = snippet.(string)
+	}
+	return includes
+}
+
 func resourceNetworkExists(d *schema.ResourceData, m interface{}) (b bool, e error) {
 	client := m.(*ZeroTierClient)
 	exists, err := client.CheckNetworkExists(d.Id())
@@ -116,45 +319,120 @@ func resourceNetworkExists(d *schema.ResourceData, m interface{}) (b bool, e err
 }
 
 func fromResourceData(d *schema.ResourceData) (*Network, error) {
-	routesRaw := d.Get("route").([]interface{})
-	var routes []Route
-	for _, raw := range routesRaw {
-		r := raw.(map[string]interface{})
-		via := r["via"].(string)
-		routes = append(routes, Route{
-			Target: r["target"].(string),
-			Via:    &via,
-		})
-	}
+	routes := expandRoutes(d.Get("route").(*schema.Set))
 	var pools []IpRange
 	for _, raw := range d.Get("assignment_pool").(*schema.Set).List() {
-		r := raw.(map[string]interface{})
-		cidr := r["cidr"].(string)
-		first, last, err := CIDRToRange(cidr)
+		first, last, err := poolRange(raw.(map[string]interface{}))
 		if err != nil {
-			first = net.ParseIP(r["first"].(string))
-			last = net.ParseIP(r["last"].(string))
+			return nil, fmt.Errorf("assignment_pool: %s", err)
 		}
 		pools = append(pools, IpRange{
 			First: first.String(),
 			Last:  last.String(),
 		})
 	}
+	// an empty rulesSource isn't sent, so Central keeps the rules it has
+	var rulesSource string
+	if d.Get("manage_rules").(bool) {
+		var err error
+		if rulesSource, err = rulesSourceFromResourceData(d); err != nil {
+			return nil, err
+		}
+	}
+	var traceTarget *string
+	if target := d.Get("remote_trace_target").(string); target != "" {
+		traceTarget = &target
+	}
 	n := &Network{
 		Id:          d.Id(),
-		RulesSource: d.Get("rules_source").(string),
+		RulesSource: rulesSource,
 		Description: d.Get("description").(string),
 		Config: &Config{
-			Name:              d.Get("name").(string),
-			Private:           d.Get("private").(bool),
-			V4AssignMode:      V4AssignModeConfig{ZT: true},
+			Name:         d.Get("name").(string),
+			Private:      d.Get("private").(bool),
+			V4AssignMode: V4AssignModeConfig{ZT: d.Get("auto_assign_v4").(bool)},
+			V6AssignMode: V6AssignModeConfig{
+				ZT:       d.Get("auto_assign_v6").(bool),
+				RFC4193:  d.Get("assign_rfc4193").(bool),
+				SixPlane: d.Get("assign_6plane").(bool),
+			},
 			Routes:            routes,
 			IpAssignmentPools: pools,
+			DNS:               expandDNS(d.Get("dns").([]interface{})),
+			MulticastLimit:    d.Get("multicast_limit").(int),
+			EnableBroadcast:   d.Get("enable_broadcast").(bool),
+			MTU:               d.Get("mtu").(int),
+			RemoteTraceTarget: traceTarget,
+			SSOConfig:         expandSSO(d.Get("sso").([]interface{})),
+			RemoteTraceLevel:  d.Get("remote_trace_level").(int),
 		},
 	}
 	return n, nil
 }
 
+func expandRoutes(routesRaw *schema.Set) []Route {
+	var routes []Route
+	for _, raw := range routesRaw.List() {
+		r := raw.(map[string]interface{})
+		route := Route{Target: r["target"].(string)}
+		// LAN routes have a null via, not an empty one
+		if via := r["via"].(string); via != "" {
+			route.Via = &via
+		}
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+// expandDNS always gives a DNS config, so that removing the dns block clears
+// it in Central too.
+func expandDNS(raw []interface{}) DNSConfig {
+	dns := DNSConfig{Servers: []string{}}
+	if len(raw) == 0 || raw[0] == nil {
+		return dns
+	}
+	m := raw[0].(map[string]interface{})
+	dns.Domain = m["domain"].(string)
+	for _, server := range m["servers"].([]interface{}) {
+		dns.Servers = append(dns.Servers, server.(string))
+	}
+	return dns
+}
+
+func expandSSO(raw []interface{}) SSOConfig {
+	if len(raw) == 0 || raw[0] == nil {
+		return SSOConfig{}
+	}
+	m := raw[0].(map[string]interface{})
+	return SSOConfig{
+		Enabled:               true,
+		Issuer:                m["issuer"].(string),
+		ClientId:              m["client_id"].(string),
+		AuthorizationEndpoint: m["authorization_endpoint"].(string),
+	}
+}
+
+// poolRange is the first and last address of an assignment pool.
+func poolRange(r map[string]interface{}) (net.IP, net.IP, error) {
+	// first and last are in state for every pool, so they're the range
+	// Central has, unless only cidr is known
+	if cidr := r["cidr"].(string); cidr != "" && (r["first"].(string) == "" || r["last"].(string) == "") {
+		return CIDRToRange(cidr)
+	}
+	first := net.ParseIP(r["first"].(string))
+	last := net.ParseIP(r["last"].(string))
+	if first == nil || last == nil {
+		return nil, nil, fmt.Errorf("%q to %q is not a range of IP addresses", r["first"], r["last"])
+	}
+	if (first.To4() == nil) != (last.To4() == nil) {
+		return nil, nil, fmt.Errorf("%s and %s are different IP versions", first, last)
+	}
+	if bytes.Compare(first.To16(), last.To16()) > 0 {
+		return nil, nil, fmt.Errorf("%s comes after %s", first, last)
+	}
+	return first, last, nil
+}
+
 func resourceNetworkCreate(d *schema.ResourceData, m interface{}) error {
 	client := m.(*ZeroTierClient)
 	n, err := fromResourceData(d)
@@ -167,6 +445,8 @@ func resourceNetworkCreate(d *schema.ResourceData, m interface{}) error {
 	}
 	d.SetId(created.Id)
 	setAssignmentPools(d, created)
+	setNetworkStatus(d, created)
+	setPrefixes(d)
 	return nil
 }
 
@@ -190,19 +470,66 @@ func resourceNetworkRead(d *schema.ResourceData, m interface{}) error {
 	d.Set("description", net.Description)
 	d.Set("private", net.Config.Private)
 	d.Set("auto_assign_v4", net.Config.V4AssignMode.ZT)
-	d.Set("rules_source", net.RulesSource)
+	d.Set("auto_assign_v6", net.Config.V6AssignMode.ZT)
+	d.Set("assign_rfc4193", net.Config.V6AssignMode.RFC4193)
+	d.Set("assign_6plane", net.Config.V6AssignMode.SixPlane)
+	d.Set("multicast_limit", net.Config.MulticastLimit)
+	d.Set("enable_broadcast", net.Config.EnableBroadcast)
+	d.Set("mtu", net.Config.MTU)
+	if net.Config.RemoteTraceTarget != nil {
+		d.Set("remote_trace_target", *net.Config.RemoteTraceTarget)
+	} else {
+		d.Set("remote_trace_target", "")
+	}
+	d.Set("remote_trace_level", net.Config.RemoteTraceLevel)
+	manageRules := managesRules(d)
+	d.Set("manage_rules", manageRules)
+	if manageRules {
+		setRulesSource(d, net)
+	}
 
 	setRoutes(d, net)
+	setDNS(d, net)
+	setSSO(d, net)
+	setNetworkStatus(d, net)
+	setPrefixes(d)
 	setAssignmentPools(d, net)
 
 	return nil
 }
 
-func setAssignmentPools(d *schema.ResourceData, n *Network) {
+// Imported state, and state from before manage_rules, has no value for it.
+// Read that as the default, or the rules would be left empty and the next
+// apply would reset them.
+func managesRules(d *schema.ResourceData) bool {
+	manage, ok := d.GetOkExists("manage_rules")
+	return !ok || manage.(bool)
+}
+
+// Networks created through the API, or on some self-hosted controllers, have
+// compiled rules but no rulesSource. Regenerate it so they can be adopted
+// without a diff against the whole default ruleset.
+func setRulesSource(d *schema.ResourceData, n *NetworkReadOnly) {
+	source := n.RulesSource
+	if source == "" && n.Config != nil && len(n.Config.Rules) > 0 {
+		decompiled, err := decompileRules(n)
+		if err != nil {
+			log.Printf("[WARN] unable to decompile rules for network %s: %s", n.Id, err)
+		} else {
+			source = decompiled
+		}
+	}
+	d.Set("rules_source", source)
+	if n.Config != nil && usesRuleBlocks(d) {
+		setRuleBlocks(d, n, source)
+	}
+}
+
+func setAssignmentPools(d *schema.ResourceData, n *NetworkReadOnly) {
 	rawPools := &schema.Set{F: resourceIpAssignmentHash}
 	for _, p := range n.Config.IpAssignmentPools {
 		raw := make(map[string]interface{})
-		// raw["cidr"] = SmallestCIDR(net.ParseIP(p.First), net.ParseIP(p.Last))
+		raw["cidr"] = poolCIDR(p.First, p.Last)
 		raw["first"] = p.First
 		raw["last"] = p.Last
 		rawPools.Add(raw)
@@ -210,15 +537,85 @@ func setAssignmentPools(d *schema.ResourceData, n *Network) {
 	d.Set("assignment_pool", rawPools)
 }
 
-func setRoutes(d *schema.ResourceData, n *Network) {
-	rawRoutes := make([]interface{}, len(n.Config.Routes))
-	for i, r := range n.Config.Routes {
+// poolCIDR is the cidr for a pool, when it is one: either the range
+// CIDRToRange gives for it, or the whole prefix. Otherwise it's empty.
+func poolCIDR(first, last string) string {
+	cidr := SmallestCIDR(net.ParseIP(first), net.ParseIP(last))
+	f, l, err := CIDRToRange(cidr)
+	if err != nil {
+		return ""
+	}
+	if f.String() == first && l.String() == last {
+		return cidr
+	}
+	_, prefix, _ := net.ParseCIDR(cidr)
+	if prefix.IP.String() == first && lastAddress(prefix).String() == last {
+		return cidr
+	}
+	return ""
+}
+
+func lastAddress(n *net.IPNet) net.IP {
+	last := make(net.IP, len(n.IP))
+	for i := range n.IP {
+		last[i] = n.IP[i] | ^n.Mask[i]
+	}
+	return last
+}
+
+// setNetworkStatus sets the attributes Central keeps up to date by itself.
+func setNetworkStatus(d *schema.ResourceData, n *NetworkReadOnly) {
+	d.Set("creation_time", strconv.FormatInt(n.Config.CreationTime, 10))
+	d.Set("last_modified", strconv.FormatInt(n.Config.LastModified, 10))
+	d.Set("revision", n.Config.Revision)
+	d.Set("online_member_count", n.OnlineMemberCount)
+	d.Set("authorized_member_count", n.AuthorizedMemberCount)
+	d.Set("total_member_count", n.TotalMemberCount)
+}
+
+func setPrefixes(d *schema.ResourceData) {
+	if prefix, err := rfc4193Prefix(d.Id()); err == nil {
+		d.Set("rfc4193_prefix", prefix)
+	}
+	if prefix, err := sixplanePrefix(d.Id()); err == nil {
+		d.Set("sixplane_prefix", prefix)
+	}
+}
+
+func setDNS(d *schema.ResourceData, n *NetworkReadOnly) {
+	dns := n.Config.DNS
+	if dns.Domain == "" && len(dns.Servers) == 0 {
+		d.Set("dns", []interface{}{})
+		return
+	}
+	d.Set("dns", []interface{}{map[string]interface{}{
+		"domain":  dns.Domain,
+		"servers": dns.Servers,
+	}})
+}
+
+func setSSO(d *schema.ResourceData, n *NetworkReadOnly) {
+	sso := n.Config.SSOConfig
+	if !sso.Enabled {
+		d.Set("sso", []interface{}{})
+		return
+	}
+	d.Set("sso", []interface{}{map[string]interface{}{
+		"issuer":                 sso.Issuer,
+		"client_id":              sso.ClientId,
+		"authorization_endpoint": sso.AuthorizationEndpoint,
+	}})
+}
+
+func setRoutes(d *schema.ResourceData, n *NetworkReadOnly) {
+	rawRoutes := &schema.Set{F: resourceNetworkRouteHash}
+	for _, r := range n.Config.Routes {
 		raw := make(map[string]interface{})
 		raw["target"] = r.Target
 		if r.Via != nil {
 			raw["via"] = *r.Via
 		}
-		rawRoutes[i] = raw
+		rawRoutes.Add(raw)
 	}
 	d.Set("route", rawRoutes)
 }
@@ -235,6 +632,7 @@ func resourceNetworkUpdate(d *schema.ResourceData, m interface{}) error {
 		return fmt.Errorf("unable to update network using ZeroTier API: %s\n\n%s", err, stringify)
 	}
 	setAssignmentPools(d, updated)
+	setNetworkStatus(d, updated)
 	return nil
 }
 
@@ -252,7 +650,11 @@ func resourceNetworkRouteHash(v interface{}) int {
 		buf.WriteString(fmt.Sprintf("%s-", v.(string)))
 	}
 
-	if v, ok := m["via"]; ok {
+	// a missing via and an empty one are both a LAN route
+	if v, ok := m["via"]; ok && v.(string) != "" {
+		if ip := net.ParseIP(v.(string)); ip != nil {
+			v = ip.String()
+		}
 		buf.WriteString(fmt.Sprintf("%s-", v.(string)))
 	}
 
@@ -263,7 +665,8 @@ func resourceIpAssignmentState(v interface{}) string {
 	var buf bytes.Buffer
 	m := v.(map[string]interface{})
 
-	if v, ok := m["cidr"]; ok && len(v.(string)) > 0 {
+	// first and last win when both are known, as they're what Central has
+	if v, ok := m["cidr"]; ok && len(v.(string)) > 0 && (m["first"] == nil || m["first"] == "" || m["last"] == nil || m["last"] == "") {
 		if first, last, err := CIDRToRange(v.(string)); err == nil {
 			buf.WriteString(fmt.Sprintf("%s-", first.String()))
 			buf.WriteString(fmt.Sprintf("%s", last.String()))
@@ -0,0 +1,137 @@
+package zerotier
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// pulled from ZT's default
+const defaultRulesSource = "#\n# Allow only IPv4, IPv4 ARP, and IPv6 Ethernet frames.\n#\ndrop\n\tnot ethertype ipv4\n\tand not ethertype arp\n\tand not ethertype ipv6\n;\n\n#\n# Uncomment to drop non-ZeroTier issued and managed IP addresses.\n#\n# This prevents IP spoofing but also blocks manual IP management at the OS level and\n# bridging unless special rules to exempt certain hosts or traffic are added before\n# this rule.\n#\n#drop\n#\tnot chr ipauth\n#;\n\n# Accept anything else. This is required since default is 'drop'.\naccept;"
+
+// rulesSchema is shared by zerotier_network and zerotier_network_rules, so
+// rules can be managed on either.
+func rulesSchema() map[string]*schema.Schema {
+	return map[string]*schema.Schema{
+		"rules_source": &schema.Schema{
+			Type:             schema.TypeString,
+			Optional:         true,
+			Default:          defaultRulesSource,
+			Set:              stringHash,
+			DiffSuppressFunc: rulesSourceDiffSuppress,
+			ConflictsWith:    []string{"rule", "capability", "tag"},
+		},
+		"rules_includes": &schema.Schema{
+			Type:     schema.TypeMap,
+			Optional: true,
+			Elem: &schema.Schema{
+				Type: schema.TypeString,
+			},
+		},
+		"rule": &schema.Schema{
+			Type:     schema.TypeList,
+			Optional: true,
+			Elem:     ruleBlock(),
+		},
+		"capability": &schema.Schema{
+			Type:     schema.TypeList,
+			Optional: true,
+			Elem:     capabilityBlock(),
+		},
+		"tag": &schema.Schema{
+			Type:     schema.TypeList,
+			Optional: true,
+			Elem:     tagBlock(),
+		},
+		"rules_warnings": &schema.Schema{
+			Type:        schema.TypeList,
+			Computed:    true,
+			Description: "Probable mistakes in the rules, found by linting them at plan.",
+			Elem: &schema.Schema{
+				Type: schema.TypeString,
+			},
+		},
+	}
+}
+
+func resourceZeroTierNetworkRules() *schema.Resource {
+	s := rulesSchema()
+	s["network_id"] = &schema.Schema{
+		Type:     schema.TypeString,
+		Required: true,
+		ForceNew: true,
+	}
+	return &schema.Resource{
+		Create:        resourceNetworkRulesCreate,
+		Read:          resourceNetworkRulesRead,
+		Update:        resourceNetworkRulesUpdate,
+		Delete:        resourceNetworkRulesDelete,
+		Exists:        resourceNetworkExists,
+		CustomizeDiff: rulesCustomizeDiff,
+		Importer: &schema.ResourceImporter{
+			State: resourceNetworkRulesImport,
+		},
+
+		Schema: s,
+	}
+}
+
+// rulesSourceFromResourceData is the rules source to send to Central, with
+// macros and includes expanded, or rendered from the rule blocks.
+func rulesSourceFromResourceData(d resourceGetter) (string, error) {
+	if usesRuleBlocks(d) {
+		return renderRuleBlocks(d)
+	}
+	rulesSource, err := expandRulesSource(d.Get("rules_source").(string), rulesIncludes(d))
+	if err != nil {
+		return "", fmt.Errorf("rules_source: %s", err)
+	}
+	return rulesSource, nil
+}
+
+func resourceNetworkRulesCreate(d *schema.ResourceData, m interface{}) error {
+	d.SetId(d.Get("network_id").(string))
+	return resourceNetworkRulesUpdate(d, m)
+}
+
+func resourceNetworkRulesRead(d *schema.ResourceData, m interface{}) error {
+	client := m.(*ZeroTierClient)
+	net, err := client.GetNetwork(d.Id())
+	if err != nil {
+		return fmt.Errorf("unable to read network from API: %s", err)
+	}
+	if net == nil {
+		d.SetId("")
+		return nil
+	}
+	d.Set("network_id", net.Id)
+	setRulesSource(d, net)
+	return nil
+}
+
+func resourceNetworkRulesUpdate(d *schema.ResourceData, m interface{}) error {
+	client := m.(*ZeroTierClient)
+	rulesSource, err := rulesSourceFromResourceData(d)
+	if err != nil {
+		return err
+	}
+	// only rulesSource is sent, so Central leaves the rest of the network alone
+	_, err = client.UpdateNetwork(d.Id(), &Network{Id: d.Id(), RulesSource: rulesSource})
+	if err != nil {
+		return fmt.Errorf("unable to update rules using ZeroTier API: %s", err)
+	}
+	return nil
+}
+
+// Deleting the rules puts the network back on ZeroTier's default rules,
+// rather than leaving the last ones in place.
+func resourceNetworkRulesDelete(d *schema.ResourceData, m interface{}) error {
+	client := m.(*ZeroTierClient)
+	_, err := client.UpdateNetwork(d.Id(), &Network{Id: d.Id(), RulesSource: defaultRulesSource})
+	return err
+}
+
+func resourceNetworkRulesImport(d *schema.ResourceData, m interface{}) ([]*schema.ResourceData, error) {
+	d.Set("network_id", d.Id())
+	return []*schema.ResourceData{d}, nil
+}
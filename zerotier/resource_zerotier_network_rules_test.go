@@ -0,0 +1,58 @@
+package zerotier
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestNetworkRules(t *testing.T) {
+	f := newFakeController(t)
+	defer f.Close()
+	f.existingNetwork(defaultRulesSource)
+	r := resourceZeroTierNetworkRules()
+	path := "/network/" + fakeNetworkId
+
+	// only rulesSource is sent, leaving the rest of the network alone
+	state := f.apply(r, nil, map[string]interface{}{
+		"network_id":   fakeNetworkId,
+		"rules_source": "drop not ethertype ipv4;\naccept;",
+	})
+	var posted map[string]interface{}
+	f.lastPosted(path, &posted)
+	want := map[string]interface{}{"id": fakeNetworkId, "rulesSource": "drop not ethertype ipv4;\naccept;"}
+	if !reflect.DeepEqual(posted, want) {
+		body, _ := json.Marshal(posted)
+		t.Errorf("created with %s", body)
+	}
+	if state.ID != fakeNetworkId || f.networks[fakeNetworkId].Config.Name != "existing" {
+		t.Errorf("id %q, network name %q", state.ID, f.networks[fakeNetworkId].Config.Name)
+	}
+
+	// destroying the rules resets them to the default
+	if _, err := r.Apply(state, &terraform.InstanceDiff{Destroy: true}, f.client()); err != nil {
+		t.Fatal(err)
+	}
+	f.lastPosted(path, &posted)
+	want = map[string]interface{}{"id": fakeNetworkId, "rulesSource": defaultRulesSource}
+	if !reflect.DeepEqual(posted, want) {
+		body, _ := json.Marshal(posted)
+		t.Errorf("destroyed with %s", body)
+	}
+}
+
+func TestNetworkRulesImport(t *testing.T) {
+	f := newFakeController(t)
+	defer f.Close()
+	f.existingNetwork(testRulesSource)
+
+	state := f.importState(resourceZeroTierNetworkRules(), fakeNetworkId)
+	if state.Attributes["network_id"] != fakeNetworkId {
+		t.Errorf("network_id is %q after import", state.Attributes["network_id"])
+	}
+	if !rulesSourceEquivalent(state.Attributes["rules_source"], testRulesSource, nil) {
+		t.Errorf("rules_source after import is:\n%s", state.Attributes["rules_source"])
+	}
+}
@@ -0,0 +1,153 @@
+package zerotier
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/config"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestNetworkSSO(t *testing.T) {
+	f := newFakeController(t)
+	defer f.Close()
+	r := resourceZeroTierNetwork()
+
+	sso := map[string]interface{}{
+		"issuer":                 "https://login.example.com/realms/zt",
+		"client_id":              "zerotier",
+		"authorization_endpoint": "https://login.example.com/realms/zt/auth",
+	}
+	state := f.apply(r, nil, map[string]interface{}{
+		"name": "sso",
+		"sso":  []interface{}{sso},
+	})
+
+	var posted struct {
+		Config struct {
+			SSOConfig map[string]interface{} `json:"ssoConfig"`
+		} `json:"config"`
+	}
+	f.lastPosted("/network", &posted)
+	want := map[string]interface{}{
+		"enabled":               true,
+		"issuer":                "https://login.example.com/realms/zt",
+		"clientId":              "zerotier",
+		"authorizationEndpoint": "https://login.example.com/realms/zt/auth",
+	}
+	if !reflect.DeepEqual(posted.Config.SSOConfig, want) {
+		t.Errorf("POSTed ssoConfig %v, want %v", posted.Config.SSOConfig, want)
+	}
+	for k, v := range map[string]string{
+		"sso.#":                        "1",
+		"sso.0.issuer":                 "https://login.example.com/realms/zt",
+		"sso.0.client_id":              "zerotier",
+		"sso.0.authorization_endpoint": "https://login.example.com/realms/zt/auth",
+	} {
+		if state.Attributes[k] != v {
+			t.Errorf("%s is %q in state, want %q", k, state.Attributes[k], v)
+		}
+	}
+
+	// taking the block out turns SSO off
+	state = f.apply(r, state, map[string]interface{}{"name": "sso"})
+	f.lastPosted("/network/"+fakeNetworkId, &posted)
+	if posted.Config.SSOConfig["enabled"] != false {
+		t.Errorf("POSTed ssoConfig %v without an sso block, want it disabled", posted.Config.SSOConfig)
+	}
+	if state.Attributes["sso.#"] != "0" {
+		t.Errorf("sso.# is %q in state without an sso block, want 0", state.Attributes["sso.#"])
+	}
+}
+
+// Imported state has no manage_rules, which should read as managing the
+// rules, so that they're read back rather than reset to the default.
+func TestNetworkImportReadsRules(t *testing.T) {
+	f := newFakeController(t)
+	defer f.Close()
+	f.existingNetwork(testRulesSource)
+	r := resourceZeroTierNetwork()
+
+	state := f.importState(r, fakeNetworkId)
+	if state.Attributes["manage_rules"] != "true" {
+		t.Errorf("manage_rules is %q after import, want true", state.Attributes["manage_rules"])
+	}
+	if !rulesSourceEquivalent(state.Attributes["rules_source"], testRulesSource, nil) {
+		t.Fatalf("rules_source after import is:\n%s", state.Attributes["rules_source"])
+	}
+
+	c, err := config.NewRawConfig(map[string]interface{}{
+		"name":         "existing",
+		"rules_source": testRulesSource,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff, err := r.Diff(state, terraform.NewResourceConfig(c), f.client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff != nil && diff.Attributes["rules_source"] != nil {
+		t.Errorf("planned a change to the imported rules: %#v", diff.Attributes["rules_source"])
+	}
+}
+
+func TestNetworkUnmanagedRules(t *testing.T) {
+	f := newFakeController(t)
+	defer f.Close()
+	r := resourceZeroTierNetwork()
+
+	for k, v := range map[string]interface{}{
+		"rules_includes": map[string]interface{}{"baseline": "accept;"},
+		"rule":           []interface{}{map[string]interface{}{"action": "accept"}},
+		"capability":     []interface{}{map[string]interface{}{"name": "ssh", "id": 1}},
+		"tag":            []interface{}{map[string]interface{}{"name": "role", "id": 1}},
+	} {
+		c, err := config.NewRawConfig(map[string]interface{}{
+			"name":         "unmanaged",
+			"manage_rules": false,
+			k:              v,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = r.Diff(nil, terraform.NewResourceConfig(c), f.client())
+		if want := k + " can't be set when manage_rules is false"; err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want an error containing %q", k, err, want)
+		}
+	}
+
+	// handing the rules over to zerotier_network_rules leaves them alone
+	state := f.apply(r, nil, map[string]interface{}{
+		"name":         "unmanaged",
+		"rules_source": "accept;",
+	})
+	state = f.apply(r, state, map[string]interface{}{
+		"name":         "unmanaged",
+		"description":  "rules elsewhere",
+		"manage_rules": false,
+	})
+	var posted Network
+	f.lastPosted("/network/"+fakeNetworkId, &posted)
+	if posted.Description != "rules elsewhere" || posted.RulesSource != "" {
+		t.Errorf("POSTed description %q and rulesSource %q", posted.Description, posted.RulesSource)
+	}
+	if state.Attributes["manage_rules"] != "false" {
+		t.Errorf("manage_rules is %q, want false", state.Attributes["manage_rules"])
+	}
+}
+
+// Times are in milliseconds, which don't fit an int on 32-bit platforms.
+func TestNetworkTimes(t *testing.T) {
+	f := newFakeController(t)
+	defer f.Close()
+	f.existingNetwork(defaultRulesSource)
+	f.networks[fakeNetworkId].Config.CreationTime = 1700000000000
+	f.networks[fakeNetworkId].Config.LastModified = 1700000123456
+
+	state := f.importState(resourceZeroTierNetwork(), fakeNetworkId)
+	if state.Attributes["creation_time"] != "1700000000000" || state.Attributes["last_modified"] != "1700000123456" {
+		t.Errorf("creation_time %q, last_modified %q", state.Attributes["creation_time"], state.Attributes["last_modified"])
+	}
+}
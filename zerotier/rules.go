@@ -0,0 +1,219 @@
+package zerotier
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Rule is one entry of a compiled rule table, as returned by Central in
+// config.rules and in each capability's rules. Matches are listed before the
+// action they apply to. Which fields are meaningful depends on Type.
+type Rule struct {
+	Type string `json:"type"`
+	Not  bool   `json:"not"`
+	Or   bool   `json:"or"`
+
+	// ACTION_TEE, ACTION_WATCH, ACTION_REDIRECT
+	Address string `json:"address"`
+	Flags   int    `json:"flags"`
+	Length  int    `json:"length"`
+
+	// MATCH_SOURCE_ZEROTIER_ADDRESS, MATCH_DEST_ZEROTIER_ADDRESS
+	Zt string `json:"zt"`
+	// MATCH_MAC_SOURCE, MATCH_MAC_DEST
+	Mac string `json:"mac"`
+	// MATCH_IPV4_*, MATCH_IPV6_*, in cidr form
+	Ip string `json:"ip"`
+
+	VlanId     int `json:"vlanId"`
+	VlanPcp    int `json:"vlanPcp"`
+	VlanDei    int `json:"vlanDei"`
+	IpProtocol int `json:"ipProtocol"`
+	EtherType  int `json:"etherType"`
+
+	// MATCH_IP_TOS, MATCH_CHARACTERISTICS
+	Mask ruleMask `json:"mask"`
+	// MATCH_IP_TOS, MATCH_*_PORT_RANGE, MATCH_FRAME_SIZE_RANGE
+	Start int `json:"start"`
+	End   int `json:"end"`
+
+	// MATCH_ICMP; a nil code matches any code
+	IcmpType int  `json:"icmpType"`
+	IcmpCode *int `json:"icmpCode"`
+
+	// MATCH_RANDOM, scaled so that 0xffffffff always matches
+	Probability uint32 `json:"probability"`
+
+	// MATCH_TAGS_*, MATCH_TAG_SENDER, MATCH_TAG_RECEIVER
+	Id    int `json:"id"`
+	Value int `json:"value"`
+}
+
+// ruleMask is a plain number for MATCH_IP_TOS, but Central renders the 64-bit
+// MATCH_CHARACTERISTICS mask as a hex string.
+type ruleMask uint64
+
+func (m *ruleMask) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		v, err := strconv.ParseUint(s, 16, 64)
+		if err != nil {
+			return fmt.Errorf("invalid rule mask %q", s)
+		}
+		*m = ruleMask(v)
+		return nil
+	}
+	var v uint64
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*m = ruleMask(v)
+	return nil
+}
+
+const (
+	ruleActionDrop     = "ACTION_DROP"
+	ruleActionAccept   = "ACTION_ACCEPT"
+	ruleActionTee      = "ACTION_TEE"
+	ruleActionWatch    = "ACTION_WATCH"
+	ruleActionRedirect = "ACTION_REDIRECT"
+	ruleActionBreak    = "ACTION_BREAK"
+
+	ruleMatchSourceZtAddress = "MATCH_SOURCE_ZEROTIER_ADDRESS"
+	ruleMatchDestZtAddress   = "MATCH_DEST_ZEROTIER_ADDRESS"
+	ruleMatchVlanId          = "MATCH_VLAN_ID"
+	ruleMatchVlanPcp         = "MATCH_VLAN_PCP"
+	ruleMatchVlanDei         = "MATCH_VLAN_DEI"
+	ruleMatchMacSource       = "MATCH_MAC_SOURCE"
+	ruleMatchMacDest         = "MATCH_MAC_DEST"
+	ruleMatchIpv4Source      = "MATCH_IPV4_SOURCE"
+	ruleMatchIpv4Dest        = "MATCH_IPV4_DEST"
+	ruleMatchIpv6Source      = "MATCH_IPV6_SOURCE"
+	ruleMatchIpv6Dest        = "MATCH_IPV6_DEST"
+	ruleMatchIpTos           = "MATCH_IP_TOS"
+	ruleMatchIpProtocol      = "MATCH_IP_PROTOCOL"
+	ruleMatchEtherType       = "MATCH_ETHERTYPE"
+	ruleMatchIcmp            = "MATCH_ICMP"
+	ruleMatchSourcePortRange = "MATCH_IP_SOURCE_PORT_RANGE"
+	ruleMatchDestPortRange   = "MATCH_IP_DEST_PORT_RANGE"
+	ruleMatchCharacteristics = "MATCH_CHARACTERISTICS"
+	ruleMatchFrameSizeRange  = "MATCH_FRAME_SIZE_RANGE"
+	ruleMatchRandom          = "MATCH_RANDOM"
+	ruleMatchTagsDifference  = "MATCH_TAGS_DIFFERENCE"
+	ruleMatchTagsBitwiseAnd  = "MATCH_TAGS_BITWISE_AND"
+	ruleMatchTagsBitwiseOr   = "MATCH_TAGS_BITWISE_OR"
+	ruleMatchTagsBitwiseXor  = "MATCH_TAGS_BITWISE_XOR"
+	ruleMatchTagsEqual       = "MATCH_TAGS_EQUAL"
+	ruleMatchTagSender       = "MATCH_TAG_SENDER"
+	ruleMatchTagReceiver     = "MATCH_TAG_RECEIVER"
+)
+
+func isRuleAction(t string) bool {
+	switch t {
+	case ruleActionDrop, ruleActionAccept, ruleActionTee, ruleActionWatch, ruleActionRedirect, ruleActionBreak:
+		return true
+	}
+	return false
+}
+
+// keywords used by the rules language, keyed by rule type
+var ruleKeywords = map[string]string{
+	ruleActionDrop:     "drop",
+	ruleActionAccept:   "accept",
+	ruleActionTee:      "tee",
+	ruleActionWatch:    "watch",
+	ruleActionRedirect: "redirect",
+	ruleActionBreak:    "break",
+
+	ruleMatchSourceZtAddress: "ztsrc",
+	ruleMatchDestZtAddress:   "ztdest",
+	ruleMatchVlanId:          "vlan",
+	ruleMatchVlanPcp:         "vlanpcp",
+	ruleMatchVlanDei:         "vlandei",
+	ruleMatchMacSource:       "macsrc",
+	ruleMatchMacDest:         "macdest",
+	ruleMatchIpv4Source:      "ipsrc",
+	ruleMatchIpv4Dest:        "ipdest",
+	ruleMatchIpv6Source:      "ipsrc",
+	ruleMatchIpv6Dest:        "ipdest",
+	ruleMatchIpTos:           "iptos",
+	ruleMatchIpProtocol:      "ipprotocol",
+	ruleMatchEtherType:       "ethertype",
+	ruleMatchIcmp:            "icmp",
+	ruleMatchSourcePortRange: "sport",
+	ruleMatchDestPortRange:   "dport",
+	ruleMatchCharacteristics: "chr",
+	ruleMatchFrameSizeRange:  "framesize",
+	ruleMatchRandom:          "random",
+	ruleMatchTagsDifference:  "tdiff",
+	ruleMatchTagsBitwiseAnd:  "tand",
+	ruleMatchTagsBitwiseOr:   "tor",
+	ruleMatchTagsBitwiseXor:  "txor",
+	ruleMatchTagsEqual:       "teq",
+	ruleMatchTagSender:       "tseq",
+	ruleMatchTagReceiver:     "treq",
+}
+
+// names the rules language accepts for ethertype, in preferred order
+var ruleEtherTypes = []struct {
+	name  string
+	value int
+}{
+	{"ipv4", 0x0800},
+	{"arp", 0x0806},
+	{"wol", 0x0842},
+	{"rarp", 0x8035},
+	{"ipv6", 0x86dd},
+	{"atalk", 0x809b},
+	{"aarp", 0x80f3},
+	{"ipx_a", 0x8137},
+	{"ipx_b", 0x8138},
+}
+
+var ruleIpProtocols = []struct {
+	name  string
+	value int
+}{
+	{"icmp", 0x01},
+	{"icmp4", 0x01},
+	{"icmpv4", 0x01},
+	{"igmp", 0x02},
+	{"ipip", 0x04},
+	{"tcp", 0x06},
+	{"egp", 0x08},
+	{"igp", 0x09},
+	{"udp", 0x11},
+	{"rdp", 0x1b},
+	{"esp", 0x32},
+	{"ah", 0x33},
+	{"icmp6", 0x3a},
+	{"icmpv6", 0x3a},
+	{"l2tp", 0x73},
+	{"sctp", 0x84},
+	{"udplite", 0x88},
+}
+
+// packet characteristics bits, matched by `chr`
+var ruleCharacteristics = []struct {
+	name string
+	bit  uint64
+}{
+	{"inbound", 0x8000000000000000},
+	{"multicast", 0x4000000000000000},
+	{"broadcast", 0x2000000000000000},
+	{"ipauth", 0x1000000000000000},
+	{"macauth", 0x0800000000000000},
+	{"tcp_fin", 0x0000000000000001},
+	{"tcp_syn", 0x0000000000000002},
+	{"tcp_rst", 0x0000000000000004},
+	{"tcp_psh", 0x0000000000000008},
+	{"tcp_ack", 0x0000000000000010},
+	{"tcp_urg", 0x0000000000000020},
+	{"tcp_ece", 0x0000000000000040},
+	{"tcp_cwr", 0x0000000000000080},
+	{"tcp_ns", 0x0000000000000100},
+	{"tcp_rs_2", 0x0000000000000200},
+	{"tcp_rs_1", 0x0000000000000400},
+	{"tcp_rs_0", 0x0000000000000800},
+}
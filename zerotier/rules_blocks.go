@@ -0,0 +1,339 @@
+package zerotier
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
+)
+
+// Structured alternative to rules_source. The rule, capability and tag blocks
+// are rendered into rules source, so Central still does the compiling.
+
+// match keywords, each of which is an attribute of a match block
+func ruleMatchKeywords() []string {
+	seen := map[string]bool{}
+	var keywords []string
+	for t, k := range ruleKeywords {
+		if !isRuleAction(t) && !seen[k] {
+			seen[k] = true
+			keywords = append(keywords, k)
+		}
+	}
+	sort.Strings(keywords)
+	return keywords
+}
+
+func ruleMatchBlock() *schema.Resource {
+	s := map[string]*schema.Schema{
+		"not": &schema.Schema{
+			Type:     schema.TypeBool,
+			Optional: true,
+			Default:  false,
+		},
+		"or": &schema.Schema{
+			Type:     schema.TypeBool,
+			Optional: true,
+			Default:  false,
+		},
+	}
+	for _, k := range ruleMatchKeywords() {
+		s[k] = &schema.Schema{
+			Type:     schema.TypeString,
+			Optional: true,
+		}
+	}
+	return &schema.Resource{Schema: s}
+}
+
+func ruleBlock() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"action": &schema.Schema{
+				Type:         schema.TypeString,
+				Required:     true,
+				ValidateFunc: validation.StringInSlice([]string{"drop", "accept", "break", "tee", "watch", "redirect"}, false),
+			},
+			// for tee, watch and redirect
+			"address": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			// for tee and watch
+			"length": &schema.Schema{
+				Type:     schema.TypeInt,
+				Optional: true,
+			},
+			"match": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem:     ruleMatchBlock(),
+			},
+		},
+	}
+}
+
+func capabilityBlock() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"name": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"id": &schema.Schema{
+				Type:     schema.TypeInt,
+				Required: true,
+			},
+			"default": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"rule": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem:     ruleBlock(),
+			},
+		},
+	}
+}
+
+func tagBlock() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"name": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"id": &schema.Schema{
+				Type:     schema.TypeInt,
+				Required: true,
+			},
+			// a string, so that a default of 0 can be told apart from none
+			"default": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validateIntString,
+			},
+			"enums": &schema.Schema{
+				Type:     schema.TypeMap,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeInt,
+				},
+			},
+			// flag name -> bit number
+			"flags": &schema.Schema{
+				Type:     schema.TypeMap,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeInt,
+				},
+			},
+		},
+	}
+}
+
+func validateIntString(i interface{}, k string) ([]string, []error) {
+	v, ok := i.(string)
+	if !ok {
+		return nil, []error{fmt.Errorf("expected type of %q to be string", k)}
+	}
+	if v == "" {
+		return nil, nil
+	}
+	if _, err := strconv.Atoi(v); err != nil {
+		return nil, []error{fmt.Errorf("%q must be a whole number, got %q", k, v)}
+	}
+	return nil, nil
+}
+
+// resourceGetter is what the rules helpers need from *schema.ResourceData, so
+// they can be used from CustomizeDiff with a *schema.ResourceDiff as well.
+type resourceGetter interface {
+	Get(key string) interface{}
+}
+
+func usesRuleBlocks(d resourceGetter) bool {
+	for _, k := range []string{"rule", "capability", "tag"} {
+		if len(d.Get(k).([]interface{})) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// renderRuleBlocks turns the rule, capability and tag blocks into rules source.
+func renderRuleBlocks(d resourceGetter) (string, error) {
+	var buf bytes.Buffer
+	for i, raw := range d.Get("tag").([]interface{}) {
+		t := raw.(map[string]interface{})
+		byName := TagByName{
+			Tag:   Tag{Id: t["id"].(int)},
+			Enums: intMap(t["enums"]),
+			Flags: intMap(t["flags"]),
+		}
+		if def := t["default"].(string); def != "" {
+			v, err := strconv.Atoi(def)
+			if err != nil {
+				return "", fmt.Errorf("tag.%d.default: %s", i, err)
+			}
+			byName.Default = &v
+		}
+		writeTag(&buf, t["name"].(string), byName.Tag, byName)
+	}
+	for i, raw := range d.Get("capability").([]interface{}) {
+		c := raw.(map[string]interface{})
+		fmt.Fprintf(&buf, "cap %s\n\tid %d\n", c["name"].(string), c["id"].(int))
+		if c["default"].(bool) {
+			buf.WriteString("\tdefault\n")
+		}
+		if err := writeRuleBlocks(&buf, "\t", c["rule"].([]interface{})); err != nil {
+			return "", fmt.Errorf("capability.%d.%s", i, err)
+		}
+		buf.WriteString(";\n\n")
+	}
+	if err := writeRuleBlocks(&buf, "", d.Get("rule").([]interface{})); err != nil {
+		return "", err
+	}
+	source := strings.TrimSuffix(buf.String(), "\n")
+	if _, err := compileRules(source, nil); err != nil {
+		return "", fmt.Errorf("rule blocks don't compile: %s\n\n%s", err, source)
+	}
+	return source, nil
+}
+
+func intMap(raw interface{}) map[string]int {
+	m := map[string]int{}
+	for k, v := range raw.(map[string]interface{}) {
+		m[k] = v.(int)
+	}
+	return m
+}
+
+func writeRuleBlocks(buf *bytes.Buffer, indent string, rules []interface{}) error {
+	for i, raw := range rules {
+		r := raw.(map[string]interface{})
+		action := r["action"].(string)
+		switch action {
+		case "tee", "watch":
+			action = fmt.Sprintf("%s %d %s", action, r["length"].(int), r["address"].(string))
+		case "redirect":
+			action = fmt.Sprintf("%s %s", action, r["address"].(string))
+		}
+		var matches []string
+		for j, rawMatch := range r["match"].([]interface{}) {
+			m, err := renderMatchBlock(rawMatch.(map[string]interface{}))
+			if err != nil {
+				return fmt.Errorf("rule.%d.match.%d: %s", i, j, err)
+			}
+			if len(matches) > 0 {
+				if rawMatch.(map[string]interface{})["or"].(bool) {
+					m = "or " + m
+				} else {
+					m = "and " + m
+				}
+			}
+			matches = append(matches, m)
+		}
+		writeStatement(buf, indent, action, matches)
+	}
+	return nil
+}
+
+func renderMatchBlock(m map[string]interface{}) (string, error) {
+	var keyword, arg string
+	for _, k := range ruleMatchKeywords() {
+		v, _ := m[k].(string)
+		if v == "" {
+			continue
+		}
+		if keyword != "" {
+			return "", fmt.Errorf("only one of %s and %s can be set in a match", keyword, k)
+		}
+		keyword, arg = k, v
+	}
+	if keyword == "" {
+		return "", fmt.Errorf("a match needs one of %s", strings.Join(ruleMatchKeywords(), ", "))
+	}
+	if m["not"].(bool) {
+		return fmt.Sprintf("not %s %s", keyword, arg), nil
+	}
+	return fmt.Sprintf("%s %s", keyword, arg), nil
+}
+
+// setRuleBlocks brings the rule blocks in line with the network's compiled
+// rules, but only if they have drifted, so that the way they were written in
+// the configuration is kept otherwise.
+func setRuleBlocks(d *schema.ResourceData, n *NetworkReadOnly, source string) {
+	if rendered, err := renderRuleBlocks(d); err == nil && rulesSourceEquivalent(rendered, source, nil) {
+		return
+	}
+	names := newRuleNames(n.CapabilitiesByName, n.TagsByName)
+	rules, err := flattenRules(n.Config.Rules, names)
+	if err != nil {
+		return
+	}
+	var caps []interface{}
+	for _, c := range n.Config.Capabilities {
+		capRules, err := flattenRules(c.Rules, names)
+		if err != nil {
+			return
+		}
+		caps = append(caps, map[string]interface{}{
+			"name":    names.capName(c.Id),
+			"id":      c.Id,
+			"default": c.Default,
+			"rule":    capRules,
+		})
+	}
+	var tags []interface{}
+	for _, t := range n.Config.Tags {
+		name := names.tagName(t.Id)
+		def := ""
+		if t.Default != nil {
+			def = strconv.Itoa(*t.Default)
+		}
+		tags = append(tags, map[string]interface{}{
+			"name":    name,
+			"id":      t.Id,
+			"default": def,
+			"enums":   n.TagsByName[name].Enums,
+			"flags":   n.TagsByName[name].Flags,
+		})
+	}
+	d.Set("rule", rules)
+	d.Set("capability", caps)
+	d.Set("tag", tags)
+}
+
+func flattenRules(rules []Rule, names *ruleNames) ([]interface{}, error) {
+	var out []interface{}
+	var matches []interface{}
+	for _, r := range rules {
+		if !isRuleAction(r.Type) {
+			keyword, arg, err := renderMatchArg(r, names)
+			if err != nil {
+				return nil, err
+			}
+			matches = append(matches, map[string]interface{}{
+				keyword: arg,
+				"not":   r.Not,
+				"or":    r.Or,
+			})
+			continue
+		}
+		out = append(out, map[string]interface{}{
+			"action":  ruleKeywords[r.Type],
+			"address": r.Address,
+			"length":  r.Length,
+			"match":   matches,
+		})
+		matches = nil
+	}
+	return out, nil
+}
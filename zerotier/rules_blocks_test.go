@@ -0,0 +1,148 @@
+package zerotier
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func TestRenderRuleBlocks(t *testing.T) {
+	r := resourceZeroTierNetworkRules()
+	d := schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{
+		"network_id": fakeNetworkId,
+		"tag": []interface{}{
+			map[string]interface{}{
+				"name":    "department",
+				"id":      1000,
+				"default": "0",
+				"enums":   map[string]interface{}{"eng": 1, "ops": 2},
+			},
+			map[string]interface{}{
+				"name":  "role",
+				"id":    1001,
+				"flags": map[string]interface{}{"admin": 0},
+			},
+		},
+		"capability": []interface{}{
+			map[string]interface{}{
+				"name": "ssh",
+				"id":   100,
+				"rule": []interface{}{
+					map[string]interface{}{
+						"action": "accept",
+						"match": []interface{}{
+							map[string]interface{}{"ipprotocol": "tcp"},
+							map[string]interface{}{"dport": "22"},
+						},
+					},
+				},
+			},
+		},
+		"rule": []interface{}{
+			map[string]interface{}{
+				"action": "drop",
+				"match": []interface{}{
+					map[string]interface{}{"ethertype": "ipv4", "not": true},
+					map[string]interface{}{"ethertype": "arp", "not": true},
+				},
+			},
+			map[string]interface{}{
+				"action":  "tee",
+				"length":  -1,
+				"address": "0123456789",
+				"match": []interface{}{
+					map[string]interface{}{"tseq": "role admin"},
+					map[string]interface{}{"teq": "department ops", "or": true},
+				},
+			},
+			map[string]interface{}{"action": "accept"},
+		},
+	})
+
+	source, err := renderRuleBlocks(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `
+tag department id 1000 default 0 enum 1 eng enum 2 ops;
+tag role id 1001 flag 0 admin;
+cap ssh id 100 accept ipprotocol tcp and dport 22;;
+drop not ethertype ipv4 and not ethertype arp;
+tee -1 0123456789 tseq role admin or teq department ops;
+accept;`
+	if !rulesSourceEquivalent(source, want, nil) {
+		t.Errorf("rendered:\n%s\nwant the same as:\n%s", source, want)
+	}
+}
+
+func TestRenderRuleBlocksErrors(t *testing.T) {
+	r := resourceZeroTierNetworkRules()
+	cases := []struct {
+		name string
+		rule map[string]interface{}
+		err  string
+	}{
+		{
+			name: "two keywords in a match",
+			rule: map[string]interface{}{
+				"action": "accept",
+				"match":  []interface{}{map[string]interface{}{"dport": "22", "sport": "22"}},
+			},
+			err: "rule.0.match.0: only one of dport and sport can be set in a match",
+		},
+		{
+			name: "empty match",
+			rule: map[string]interface{}{
+				"action": "accept",
+				"match":  []interface{}{map[string]interface{}{"not": true}},
+			},
+			err: "rule.0.match.0: a match needs one of",
+		},
+		{
+			name: "doesn't compile",
+			rule: map[string]interface{}{
+				"action": "accept",
+				"match":  []interface{}{map[string]interface{}{"ethertype": "nope"}},
+			},
+			err: `rule blocks don't compile: line 2, column 12: unknown ethertype "nope"`,
+		},
+	}
+	for _, c := range cases {
+		d := schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{
+			"network_id": fakeNetworkId,
+			"rule":       []interface{}{c.rule},
+		})
+		_, err := renderRuleBlocks(d)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: got %v, want an error containing %q", c.name, err, c.err)
+		}
+	}
+}
+
+// Blocks read back from a network's compiled rules render the same rules.
+func TestSetRuleBlocks(t *testing.T) {
+	c, err := compileRules(testRulesSource, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &NetworkReadOnly{
+		Config:             &ConfigReadOnly{Rules: c.Rules, Capabilities: c.Capabilities, Tags: c.Tags},
+		CapabilitiesByName: c.CapabilitiesByName,
+		TagsByName:         c.TagsByName,
+	}
+	r := resourceZeroTierNetworkRules()
+	d := schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{"network_id": fakeNetworkId})
+	setRuleBlocks(d, n, testRulesSource)
+
+	if !usesRuleBlocks(d) {
+		t.Fatalf("no blocks were set")
+	}
+	source, err := renderRuleBlocks(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rulesSourceEquivalent(source, testRulesSource, nil) {
+		t.Errorf("blocks read from the network render as:\n%s", source)
+	}
+}
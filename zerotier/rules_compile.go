@@ -0,0 +1,669 @@
+package zerotier
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// compiledRules is what Central produces from a rules source: the base rule
+// table, the capabilities and tags, and the names they were given.
+type compiledRules struct {
+	Rules              []Rule
+	Capabilities       []Capability
+	Tags               []Tag
+	CapabilitiesByName map[string]int
+	TagsByName         map[string]TagByName
+}
+
+type ruleToken struct {
+	text string
+	line int
+	col  int
+	// where the token came from if not the rules source itself, eg
+	// rules_includes.baseline
+	source string
+}
+
+// where describes the token's position for error messages.
+func (t ruleToken) where() string {
+	if t.source != "" {
+		return fmt.Sprintf("%s line %d", t.source, t.line)
+	}
+	return fmt.Sprintf("line %d", t.line)
+}
+
+type ruleError struct {
+	tok ruleToken
+	msg string
+}
+
+func (e *ruleError) Error() string {
+	return fmt.Sprintf("%s, column %d: %s", e.tok.where(), e.tok.col, e.msg)
+}
+
+// tokenizeRules splits rules source into words and semicolons, dropping
+// comments and whitespace.
+func tokenizeRules(source string) []ruleToken {
+	var tokens []ruleToken
+	var cur []rune
+	line, col := 1, 0
+	startLine, startCol := 0, 0
+	comment := false
+	flush := func() {
+		if len(cur) > 0 {
+			tokens = append(tokens, ruleToken{text: string(cur), line: startLine, col: startCol})
+			cur = nil
+		}
+	}
+	for _, c := range source {
+		col++
+		switch {
+		case c == '\n':
+			flush()
+			comment = false
+			line++
+			col = 0
+		case comment:
+		case c == '#':
+			flush()
+			comment = true
+		case unicode.IsSpace(c):
+			flush()
+		case c == ';':
+			flush()
+			tokens = append(tokens, ruleToken{text: ";", line: line, col: col})
+		default:
+			if len(cur) == 0 {
+				startLine, startCol = line, col
+			}
+			cur = append(cur, c)
+		}
+	}
+	flush()
+	return tokens
+}
+
+type ruleParser struct {
+	tokens []ruleToken
+	pos    int
+	out    *compiledRules
+	// tags from a previous pass, so they can be referenced before they are defined
+	known map[string]TagByName
+
+	// where things were defined, for linting
+	statements []ruleStatement
+	tagDefs    []ruleDefinition
+	capDefs    []ruleDefinition
+}
+
+// ruleStatement is one action and its matches, as written in the source.
+type ruleStatement struct {
+	tok ruleToken
+	// empty for the network's base rules
+	capability string
+	rules      []Rule
+}
+
+type ruleDefinition struct {
+	tok  ruleToken
+	name string
+	id   int
+}
+
+// compileRules compiles rules source the way Central does, for comparing and
+// checking rules without a round trip to the API. includes are the snippets
+// from rules_includes, if any.
+func compileRules(source string, includes map[string]string) (*compiledRules, error) {
+	p, err := parseRules(source, includes)
+	if err != nil {
+		return nil, err
+	}
+	return p.out, nil
+}
+
+func parseRules(source string, includes map[string]string) (*ruleParser, error) {
+	tokens, err := expandRuleMacros(tokenizeRules(source), includes)
+	if err != nil {
+		return nil, err
+	}
+	var known map[string]TagByName
+	for pass := 0; pass < 2; pass++ {
+		p := &ruleParser{
+			tokens: tokens,
+			out: &compiledRules{
+				CapabilitiesByName: map[string]int{},
+				TagsByName:         map[string]TagByName{},
+			},
+			known: known,
+		}
+		err := p.parse()
+		if pass == 1 {
+			if err != nil {
+				return nil, err
+			}
+			return p, nil
+		}
+		known = p.out.TagsByName
+	}
+	return nil, nil
+}
+
+func (p *ruleParser) errorf(tok ruleToken, format string, args ...interface{}) error {
+	return &ruleError{tok: tok, msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *ruleParser) peek() (ruleToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return ruleToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *ruleParser) next() (ruleToken, error) {
+	if p.pos >= len(p.tokens) {
+		last := ruleToken{line: 1, col: 1}
+		if len(p.tokens) > 0 {
+			last = p.tokens[len(p.tokens)-1]
+		}
+		return ruleToken{}, p.errorf(last, "unexpected end of rules, missing ';'?")
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+	return tok, nil
+}
+
+func (p *ruleParser) nextInt() (int, ruleToken, error) {
+	tok, err := p.next()
+	if err != nil {
+		return 0, tok, err
+	}
+	i, err := strconv.ParseInt(tok.text, 0, 64)
+	if err != nil {
+		return 0, tok, p.errorf(tok, "expected a number, got %q", tok.text)
+	}
+	return int(i), tok, nil
+}
+
+func (p *ruleParser) parse() error {
+	for {
+		tok, ok := p.peek()
+		if !ok {
+			return nil
+		}
+		p.pos++
+		switch tok.text {
+		case "tag":
+			if err := p.parseTag(); err != nil {
+				return err
+			}
+		case "cap":
+			if err := p.parseCap(); err != nil {
+				return err
+			}
+		default:
+			rules, err := p.parseRule(tok)
+			if err != nil {
+				return err
+			}
+			p.statements = append(p.statements, ruleStatement{tok: tok, rules: rules})
+			p.out.Rules = append(p.out.Rules, rules...)
+		}
+	}
+}
+
+func (p *ruleParser) parseName(what string) (ruleToken, error) {
+	tok, err := p.next()
+	if err != nil {
+		return tok, err
+	}
+	if tok.text == ";" {
+		return tok, p.errorf(tok, "expected a %s name", what)
+	}
+	return tok, nil
+}
+
+func (p *ruleParser) expect(text string) error {
+	tok, err := p.next()
+	if err != nil {
+		return err
+	}
+	if tok.text != text {
+		return p.errorf(tok, "expected %q, got %q", text, tok.text)
+	}
+	return nil
+}
+
+// tag <name> id <id> [default <value>] [enum <value> <name>]... [flag <bit> <name>]... ;
+func (p *ruleParser) parseTag() error {
+	name, err := p.parseName("tag")
+	if err != nil {
+		return err
+	}
+	if _, dup := p.out.TagsByName[name.text]; dup {
+		return p.errorf(name, "tag %q is already defined", name.text)
+	}
+	if err := p.expect("id"); err != nil {
+		return err
+	}
+	id, _, err := p.nextInt()
+	if err != nil {
+		return err
+	}
+	tag := TagByName{
+		Tag:   Tag{Id: id},
+		Enums: map[string]int{},
+		Flags: map[string]int{},
+	}
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return err
+		}
+		switch tok.text {
+		case ";":
+			p.tagDefs = append(p.tagDefs, ruleDefinition{tok: name, name: name.text, id: id})
+			p.out.Tags = append(p.out.Tags, tag.Tag)
+			p.out.TagsByName[name.text] = tag
+			return nil
+		case "default":
+			v, _, err := p.nextInt()
+			if err != nil {
+				return err
+			}
+			tag.Default = &v
+		case "enum", "flag":
+			v, _, err := p.nextInt()
+			if err != nil {
+				return err
+			}
+			enumName, err := p.parseName(tok.text)
+			if err != nil {
+				return err
+			}
+			if tok.text == "enum" {
+				tag.Enums[enumName.text] = v
+			} else {
+				if v < 0 || v > 31 {
+					return p.errorf(tok, "flag bit must be between 0 and 31")
+				}
+				tag.Flags[enumName.text] = v
+			}
+		default:
+			return p.errorf(tok, "unexpected %q in tag %s", tok.text, name.text)
+		}
+	}
+}
+
+// cap <name> id <id> [default] <rules>... ;
+func (p *ruleParser) parseCap() error {
+	name, err := p.parseName("capability")
+	if err != nil {
+		return err
+	}
+	if _, dup := p.out.CapabilitiesByName[name.text]; dup {
+		return p.errorf(name, "capability %q is already defined", name.text)
+	}
+	if err := p.expect("id"); err != nil {
+		return err
+	}
+	id, _, err := p.nextInt()
+	if err != nil {
+		return err
+	}
+	capability := Capability{Id: id, Rules: []Rule{}}
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return err
+		}
+		switch tok.text {
+		case ";":
+			p.capDefs = append(p.capDefs, ruleDefinition{tok: name, name: name.text, id: id})
+			p.out.Capabilities = append(p.out.Capabilities, capability)
+			p.out.CapabilitiesByName[name.text] = id
+			return nil
+		case "default":
+			capability.Default = true
+		default:
+			rules, err := p.parseRule(tok)
+			if err != nil {
+				return err
+			}
+			p.statements = append(p.statements, ruleStatement{tok: tok, capability: name.text, rules: rules})
+			capability.Rules = append(capability.Rules, rules...)
+		}
+	}
+}
+
+// parseRule parses an action and its matches up to the closing ';', and
+// returns them in table order, ie the matches followed by the action.
+func (p *ruleParser) parseRule(actionTok ruleToken) ([]Rule, error) {
+	action, err := p.parseAction(actionTok)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if tok.text == ";" {
+			return append(rules, action), nil
+		}
+		r := Rule{}
+		if tok.text == "and" || tok.text == "or" {
+			r.Or = tok.text == "or"
+			if tok, err = p.next(); err != nil {
+				return nil, err
+			}
+		}
+		if tok.text == "not" {
+			r.Not = true
+			if tok, err = p.next(); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.parseMatch(tok, &r); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+}
+
+func (p *ruleParser) parseAction(tok ruleToken) (Rule, error) {
+	r := Rule{}
+	for t, keyword := range ruleKeywords {
+		if keyword == tok.text && isRuleAction(t) {
+			r.Type = t
+		}
+	}
+	switch r.Type {
+	case "":
+		return r, p.errorf(tok, "expected an action (drop, accept, break, tee, watch, redirect), tag or cap, got %q", tok.text)
+	case ruleActionTee, ruleActionWatch:
+		length, _, err := p.nextInt()
+		if err != nil {
+			return r, err
+		}
+		r.Length = length
+		fallthrough
+	case ruleActionRedirect:
+		addr, err := p.next()
+		if err != nil {
+			return r, err
+		}
+		if !isZeroTierAddress(addr.text) {
+			return r, p.errorf(addr, "expected a 10 digit ZeroTier address, got %q", addr.text)
+		}
+		r.Address = strings.ToLower(addr.text)
+	}
+	return r, nil
+}
+
+func isZeroTierAddress(s string) bool {
+	if len(s) != 10 {
+		return false
+	}
+	_, err := strconv.ParseUint(s, 16, 64)
+	return err == nil
+}
+
+func (p *ruleParser) parseRange(tok ruleToken) (int, int, error) {
+	parts := strings.SplitN(tok.text, "-", 2)
+	start, err := strconv.ParseInt(parts[0], 0, 64)
+	if err != nil {
+		return 0, 0, p.errorf(tok, "expected a number or range, got %q", tok.text)
+	}
+	end := start
+	if len(parts) == 2 {
+		end, err = strconv.ParseInt(parts[1], 0, 64)
+		if err != nil || end < start {
+			return 0, 0, p.errorf(tok, "expected a number or range, got %q", tok.text)
+		}
+	}
+	return int(start), int(end), nil
+}
+
+func (p *ruleParser) parseMatch(keyword ruleToken, r *Rule) error {
+	for t, k := range ruleKeywords {
+		if k == keyword.text && !isRuleAction(t) {
+			r.Type = t
+			break
+		}
+	}
+	if r.Type == "" {
+		return p.errorf(keyword, "unknown match %q", keyword.text)
+	}
+	tok, err := p.next()
+	if err != nil {
+		return err
+	}
+	switch keyword.text {
+	case "ztsrc", "ztdest":
+		if !isZeroTierAddress(tok.text) {
+			return p.errorf(tok, "expected a 10 digit ZeroTier address, got %q", tok.text)
+		}
+		r.Zt = strings.ToLower(tok.text)
+	case "vlan", "vlanpcp", "vlandei":
+		v, err := strconv.ParseInt(tok.text, 0, 64)
+		if err != nil {
+			return p.errorf(tok, "expected a number, got %q", tok.text)
+		}
+		switch keyword.text {
+		case "vlan":
+			r.VlanId = int(v)
+		case "vlanpcp":
+			r.VlanPcp = int(v)
+		case "vlandei":
+			r.VlanDei = int(v)
+		}
+	case "macsrc", "macdest":
+		mac, err := net.ParseMAC(tok.text)
+		if err != nil || len(mac) != 6 {
+			return p.errorf(tok, "expected a MAC address, got %q", tok.text)
+		}
+		r.Mac = mac.String()
+	case "ipsrc", "ipdest":
+		ip, bits, err := parseRuleIp(tok.text)
+		if err != nil {
+			return p.errorf(tok, "%s", err)
+		}
+		r.Ip = fmt.Sprintf("%s/%d", ip, bits)
+		if ip.To4() == nil {
+			if keyword.text == "ipsrc" {
+				r.Type = ruleMatchIpv6Source
+			} else {
+				r.Type = ruleMatchIpv6Dest
+			}
+		} else if keyword.text == "ipsrc" {
+			r.Type = ruleMatchIpv4Source
+		} else {
+			r.Type = ruleMatchIpv4Dest
+		}
+	case "iptos":
+		mask, err := strconv.ParseUint(tok.text, 0, 8)
+		if err != nil {
+			return p.errorf(tok, "expected a TOS mask, got %q", tok.text)
+		}
+		r.Mask = ruleMask(mask)
+		if tok, err = p.next(); err != nil {
+			return err
+		}
+		if r.Start, r.End, err = p.parseRange(tok); err != nil {
+			return err
+		}
+	case "ipprotocol":
+		v, ok := lookupRuleName(tok.text, ruleIpProtocols)
+		if !ok {
+			return p.errorf(tok, "unknown IP protocol %q", tok.text)
+		}
+		r.IpProtocol = v
+	case "ethertype":
+		v, ok := lookupRuleName(tok.text, ruleEtherTypes)
+		if !ok {
+			return p.errorf(tok, "unknown ethertype %q", tok.text)
+		}
+		r.EtherType = v
+	case "icmp":
+		v, err := strconv.ParseInt(tok.text, 0, 64)
+		if err != nil {
+			return p.errorf(tok, "expected an ICMP type, got %q", tok.text)
+		}
+		r.IcmpType = int(v)
+		if tok, err = p.next(); err != nil {
+			return err
+		}
+		if tok.text != "-" {
+			code, err := strconv.ParseInt(tok.text, 0, 64)
+			if err != nil {
+				return p.errorf(tok, "expected an ICMP code or -, got %q", tok.text)
+			}
+			c := int(code)
+			r.IcmpCode = &c
+		}
+	case "sport", "dport", "framesize":
+		if r.Start, r.End, err = p.parseRange(tok); err != nil {
+			return err
+		}
+	case "chr":
+		for _, name := range strings.Split(tok.text, ",") {
+			found := false
+			for _, c := range ruleCharacteristics {
+				if c.name == name {
+					r.Mask |= ruleMask(c.bit)
+					found = true
+				}
+			}
+			if !found {
+				return p.errorf(tok, "unknown packet characteristic %q", name)
+			}
+		}
+	case "random":
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil || f < 0 || f > 1 {
+			return p.errorf(tok, "expected a probability between 0 and 1, got %q", tok.text)
+		}
+		r.Probability = uint32(math.Round(f * 0xffffffff))
+	default:
+		// tdiff, tand, tor, txor, teq, tseq, treq
+		return p.parseTagMatch(tok, r)
+	}
+	return nil
+}
+
+func (p *ruleParser) parseTagMatch(tagTok ruleToken, r *Rule) error {
+	tag, named := p.known[tagTok.text]
+	if named {
+		r.Id = tag.Id
+	} else if id, err := strconv.ParseInt(tagTok.text, 0, 64); err == nil {
+		r.Id = int(id)
+	} else if p.known != nil {
+		return p.errorf(tagTok, "tag %q is not defined", tagTok.text)
+	}
+	tok, err := p.next()
+	if err != nil {
+		return err
+	}
+	if v, err := strconv.ParseInt(tok.text, 0, 64); err == nil {
+		r.Value = int(v)
+		return nil
+	}
+	if v, ok := tag.Enums[tok.text]; ok {
+		r.Value = v
+		return nil
+	}
+	if bit, ok := tag.Flags[tok.text]; ok {
+		r.Value = 1 << uint(bit)
+		return nil
+	}
+	if p.known == nil {
+		return nil
+	}
+	return p.errorf(tok, "%q is not a value of tag %s", tok.text, tagTok.text)
+}
+
+func parseRuleIp(s string) (net.IP, int, error) {
+	if strings.Contains(s, "/") {
+		ip, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, 0, fmt.Errorf("expected an IP address or CIDR, got %q", s)
+		}
+		bits, _ := ipnet.Mask.Size()
+		if v4 := ip.To4(); v4 != nil {
+			ip = v4
+		}
+		return ip, bits, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, 0, fmt.Errorf("expected an IP address or CIDR, got %q", s)
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4, 32, nil
+	}
+	return ip, 128, nil
+}
+
+func lookupRuleName(s string, table []struct {
+	name  string
+	value int
+}) (int, bool) {
+	for _, e := range table {
+		if e.name == s {
+			return e.value, true
+		}
+	}
+	v, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		return 0, false
+	}
+	return int(v), true
+}
+
+// rulesSourceEquivalent reports whether two rules sources differ only in
+// comments and whitespace, or compile to the same thing.
+func rulesSourceEquivalent(a, b string, includes map[string]string) bool {
+	ta, tb := tokenizeRules(a), tokenizeRules(b)
+	if len(ta) == len(tb) {
+		same := true
+		for i := range ta {
+			if ta[i].text != tb[i].text {
+				same = false
+				break
+			}
+		}
+		if same {
+			return true
+		}
+	}
+	ca, err := compileRules(a, includes)
+	if err != nil {
+		return false
+	}
+	cb, err := compileRules(b, includes)
+	if err != nil {
+		return false
+	}
+	return reflect.DeepEqual(ca, cb)
+}
+
+// compiledFromNetwork gathers the rules Central has already compiled for a
+// network.
+func compiledFromNetwork(n *NetworkReadOnly) *compiledRules {
+	c := &compiledRules{
+		CapabilitiesByName: n.CapabilitiesByName,
+		TagsByName:         n.TagsByName,
+	}
+	if n.Config != nil {
+		c.Rules = n.Config.Rules
+		c.Capabilities = n.Config.Capabilities
+		c.Tags = n.Config.Tags
+	}
+	return c
+}
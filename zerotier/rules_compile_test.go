@@ -0,0 +1,181 @@
+package zerotier
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// exercises tags with enums, flags and defaults, default and granted
+// capabilities, and most kinds of match
+const testRulesSource = `
+tag department
+	id 1000
+	default 1
+	enum 1 eng
+	enum 2 ops
+	enum 3 sales
+;
+
+tag role
+	id 1001
+	flag 0 admin
+	flag 1 oncall
+;
+
+cap ssh
+	id 100
+	accept ipprotocol tcp and dport 22;
+;
+
+cap monitoring
+	id 101
+	default
+	drop not ipsrc 10.0.0.0/8;
+	accept ipprotocol udp and dport 161-162;
+	accept icmp 8 -;
+	accept ethertype ipv6 and ipdest fd00::/8;
+;
+
+# Allow only IPv4, IPv4 ARP, and IPv6 Ethernet frames.
+drop
+	not ethertype ipv4
+	and not ethertype arp
+	and not ethertype ipv6
+;
+
+tee -1 0123456789 chr tcp_syn,tcp_rst;
+redirect aabbccddee ztdest 1122334455 and macsrc 01:02:03:04:05:06;
+break not chr ipauth;
+drop tseq role admin or treq role oncall and random 0.5;
+accept teq department eng;
+accept tand role 3 and tdiff department 1 and tor role 1 and txor role 2;
+accept iptos 0xfc 4-8 and framesize 64-1500 and sport 1024-65535 and icmp 3 4;
+accept ipprotocol tcp and chr tcp_ack and not chr tcp_syn;
+`
+
+func TestCompileRules(t *testing.T) {
+	c, err := compileRules(testRulesSource, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.CapabilitiesByName, map[string]int{"ssh": 100, "monitoring": 101}) {
+		t.Errorf("capabilitiesByName %v", c.CapabilitiesByName)
+	}
+	department := c.TagsByName["department"]
+	if department.Id != 1000 || department.Default == nil || *department.Default != 1 || department.Enums["ops"] != 2 {
+		t.Errorf("department %+v", department)
+	}
+	if role := c.TagsByName["role"]; role.Id != 1001 || role.Flags["oncall"] != 1 {
+		t.Errorf("role %+v", role)
+	}
+	if len(c.Capabilities) != 2 || c.Capabilities[0].Default || !c.Capabilities[1].Default {
+		t.Errorf("capabilities %+v", c.Capabilities)
+	}
+
+	// the first statement of the base rules: matches, then the action
+	want := []Rule{
+		{Type: ruleMatchEtherType, Not: true, EtherType: 0x0800},
+		{Type: ruleMatchEtherType, Not: true, EtherType: 0x0806},
+		{Type: ruleMatchEtherType, Not: true, EtherType: 0x86dd},
+		{Type: ruleActionDrop},
+	}
+	if !reflect.DeepEqual(c.Rules[:4], want) {
+		t.Errorf("first statement compiled to %+v", c.Rules[:4])
+	}
+	// flag names are their bit's value, enums their number
+	for _, r := range c.Rules {
+		if r.Type == ruleMatchTagReceiver && r.Value != 2 {
+			t.Errorf("treq role oncall compiled to value %d", r.Value)
+		}
+		if r.Type == ruleMatchTagsEqual && r.Value != 1 {
+			t.Errorf("teq department eng compiled to value %d", r.Value)
+		}
+	}
+}
+
+func TestCompileRulesErrors(t *testing.T) {
+	cases := []struct {
+		source, err string
+	}{
+		{"accept", "line 1"},
+		{"frobnicate;", `expected an action`},
+		{"accept dport;", "line 1"},
+		{"accept\n\tfrob 1;", `line 2, column 2: unknown match "frob"`},
+		{"accept ethertype nope;", `unknown ethertype "nope"`},
+		{"accept teq nope 1;", `tag "nope" is not defined`},
+		{"tag t id 1 enum 1 a;\naccept teq t b;", `"b" is not a value of tag t`},
+		{"tag t id 1 flag 32 a;", "flag bit must be between 0 and 31"},
+		{"tag t id 1;\ntag t id 2;", `tag "t" is already defined`},
+		{"cap c id 1 accept;;\ncap c id 2 accept;;", `capability "c" is already defined`},
+		{"accept dport 22-20;", "expected a number or range"},
+		{"redirect 123 ;", "expected a 10 digit ZeroTier address"},
+		{"accept random 2;", "expected a probability between 0 and 1"},
+	}
+	for _, c := range cases {
+		_, err := compileRules(c.source, nil)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%q: got %v, want an error containing %q", c.source, err, c.err)
+		}
+	}
+}
+
+// Tags can be used before they're defined.
+func TestCompileRulesTagsBeforeDefinition(t *testing.T) {
+	c, err := compileRules("accept teq department ops;\ntag department id 7 enum 2 ops;", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Rules[0].Id != 7 || c.Rules[0].Value != 2 {
+		t.Errorf("compiled to %+v", c.Rules[0])
+	}
+}
+
+func TestRulesSourceEquivalent(t *testing.T) {
+	base := "drop not ethertype ipv4 and not ethertype arp;\naccept;"
+	cases := []struct {
+		name  string
+		other string
+		same  bool
+	}{
+		{"identical", base, true},
+		{"reindented", "drop\n\tnot ethertype ipv4\n\tand not ethertype arp\n;\n\naccept;\n", true},
+		{"comments", "# only v4\ndrop not ethertype ipv4 # and arp\n and not ethertype arp;\n# the rest\naccept;", true},
+		{"CRLF", "drop not ethertype ipv4 and not ethertype arp;\r\naccept;\r\n", true},
+		{"tabs and trailing spaces", "drop   not\tethertype ipv4  and not ethertype arp ;  \naccept ;\t", true},
+		{"numbers for names", "drop not ethertype 0x0800 and not ethertype 0x0806;\naccept;", true},
+		{"different ethertype", "drop not ethertype ipv4 and not ethertype ipv6;\naccept;", false},
+		{"or instead of and", "drop not ethertype ipv4 or not ethertype arp;\naccept;", false},
+		{"commented out", "drop not ethertype ipv4 and not ethertype arp;\n#accept;", false},
+		{"doesn't compile", "drop not ethertype ipv4 and not ethertype arp;\naccept", false},
+		{"empty", "", false},
+	}
+	for _, c := range cases {
+		if got := rulesSourceEquivalent(base, c.other, nil); got != c.same {
+			t.Errorf("%s: equivalent is %v, want %v", c.name, got, c.same)
+		}
+		if got := rulesSourceEquivalent(c.other, base, nil); got != c.same {
+			t.Errorf("%s, the other way round: equivalent is %v, want %v", c.name, got, c.same)
+		}
+	}
+
+	// tags renamed but otherwise the same compile to different names
+	if rulesSourceEquivalent("tag a id 1; accept teq a 1;", "tag b id 1; accept teq b 1;", nil) {
+		t.Errorf("renaming a tag should be a change")
+	}
+}
+
+func TestRulesSourceDiffSuppress(t *testing.T) {
+	r := resourceZeroTierNetworkRules()
+	d := r.TestResourceData()
+	d.Set("network_id", fakeNetworkId)
+	if !rulesSourceDiffSuppress("rules_source", defaultRulesSource, strings.Replace(defaultRulesSource, "\n", "\r\n", -1), d) {
+		t.Errorf("CRLF line endings show as a diff")
+	}
+	if !rulesSourceDiffSuppress("rules_source", defaultRulesSource, "drop not ethertype ipv4 and not ethertype arp and not ethertype ipv6; accept;", d) {
+		t.Errorf("dropping comments and whitespace shows as a diff")
+	}
+	if rulesSourceDiffSuppress("rules_source", defaultRulesSource, "drop not ethertype ipv4 and not ethertype arp; accept;", d) {
+		t.Errorf("allowing ipv6 doesn't show as a diff")
+	}
+}
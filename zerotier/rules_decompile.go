@@ -0,0 +1,271 @@
+package zerotier
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ruleNames maps the numeric ids in a compiled rule table back to the names
+// they had in the source, falling back to generated ones when the controller
+// doesn't know them (eg self-hosted controllers have no *ByName maps).
+type ruleNames struct {
+	caps map[int]string
+	tags map[int]string
+	// tag id -> value -> enum name
+	enums map[int]map[int]string
+}
+
+func newRuleNames(capsByName map[string]int, tagsByName map[string]TagByName) *ruleNames {
+	names := &ruleNames{
+		caps:  map[int]string{},
+		tags:  map[int]string{},
+		enums: map[int]map[int]string{},
+	}
+	for name, id := range capsByName {
+		names.caps[id] = name
+	}
+	for name, t := range tagsByName {
+		names.tags[t.Id] = name
+		enums := map[int]string{}
+		for enum, v := range t.Enums {
+			enums[v] = enum
+		}
+		names.enums[t.Id] = enums
+	}
+	return names
+}
+
+func (names *ruleNames) capName(id int) string {
+	if name, ok := names.caps[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("cap_%d", id)
+}
+
+func (names *ruleNames) tagName(id int) string {
+	if name, ok := names.tags[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("tag_%d", id)
+}
+
+func (names *ruleNames) tagValue(id int, value int) string {
+	if enum, ok := names.enums[id][value]; ok {
+		return enum
+	}
+	return strconv.Itoa(value)
+}
+
+// decompileRules regenerates rules source from the compiled rules,
+// capabilities and tags of a network. Comments and formatting are lost, but
+// compiling the result gives back the same tables.
+func decompileRules(n *NetworkReadOnly) (string, error) {
+	if n.Config == nil {
+		return "", fmt.Errorf("network has no config")
+	}
+	names := newRuleNames(n.CapabilitiesByName, n.TagsByName)
+	var buf bytes.Buffer
+
+	tags := append([]Tag{}, n.Config.Tags...)
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Id < tags[j].Id })
+	for _, t := range tags {
+		name := names.tagName(t.Id)
+		writeTag(&buf, name, t, n.TagsByName[name])
+	}
+
+	caps := append([]Capability{}, n.Config.Capabilities...)
+	sort.Slice(caps, func(i, j int) bool { return caps[i].Id < caps[j].Id })
+	for _, c := range caps {
+		fmt.Fprintf(&buf, "cap %s\n\tid %d\n", names.capName(c.Id), c.Id)
+		if c.Default {
+			buf.WriteString("\tdefault\n")
+		}
+		if err := writeRuleSet(&buf, c.Rules, "\t", names); err != nil {
+			return "", fmt.Errorf("capability %s: %s", names.capName(c.Id), err)
+		}
+		buf.WriteString(";\n\n")
+	}
+
+	if err := writeRuleSet(&buf, n.Config.Rules, "", names); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+func writeTag(buf *bytes.Buffer, name string, t Tag, byName TagByName) {
+	fmt.Fprintf(buf, "tag %s\n\tid %d\n", name, t.Id)
+	if t.Default != nil {
+		fmt.Fprintf(buf, "\tdefault %d\n", *t.Default)
+	}
+	for _, e := range sortedByValue(byName.Enums) {
+		fmt.Fprintf(buf, "\tenum %d %s\n", byName.Enums[e], e)
+	}
+	for _, f := range sortedByValue(byName.Flags) {
+		fmt.Fprintf(buf, "\tflag %d %s\n", byName.Flags[f], f)
+	}
+	buf.WriteString(";\n\n")
+}
+
+func sortedByValue(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if m[keys[i]] != m[keys[j]] {
+			return m[keys[i]] < m[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// writeRuleSet writes one statement per action, with the matches that precede
+// it in the table on their own lines.
+func writeRuleSet(buf *bytes.Buffer, rules []Rule, indent string, names *ruleNames) error {
+	var matches []string
+	for _, r := range rules {
+		if !isRuleAction(r.Type) {
+			m, err := renderMatch(r, names)
+			if err != nil {
+				return err
+			}
+			if len(matches) > 0 {
+				if r.Or {
+					m = "or " + m
+				} else {
+					m = "and " + m
+				}
+			}
+			matches = append(matches, m)
+			continue
+		}
+		action, err := renderAction(r)
+		if err != nil {
+			return err
+		}
+		writeStatement(buf, indent, action, matches)
+		matches = nil
+	}
+	if len(matches) > 0 {
+		return fmt.Errorf("rule table ends with matches but no action")
+	}
+	return nil
+}
+
+// writeStatement writes an action and its matches, which should already be
+// joined with and/or.
+func writeStatement(buf *bytes.Buffer, indent string, action string, matches []string) {
+	if len(matches) == 0 {
+		fmt.Fprintf(buf, "%s%s;\n", indent, action)
+		return
+	}
+	fmt.Fprintf(buf, "%s%s\n", indent, action)
+	for _, m := range matches {
+		fmt.Fprintf(buf, "%s\t%s\n", indent, m)
+	}
+	fmt.Fprintf(buf, "%s;\n", indent)
+}
+
+func renderAction(r Rule) (string, error) {
+	switch r.Type {
+	case ruleActionDrop, ruleActionAccept, ruleActionBreak:
+		return ruleKeywords[r.Type], nil
+	case ruleActionTee, ruleActionWatch:
+		return fmt.Sprintf("%s %d %s", ruleKeywords[r.Type], r.Length, r.Address), nil
+	case ruleActionRedirect:
+		return fmt.Sprintf("%s %s", ruleKeywords[r.Type], r.Address), nil
+	}
+	return "", fmt.Errorf("unsupported rule action %q", r.Type)
+}
+
+func renderMatch(r Rule, names *ruleNames) (string, error) {
+	keyword, arg, err := renderMatchArg(r, names)
+	if err != nil {
+		return "", err
+	}
+	if r.Not {
+		return fmt.Sprintf("not %s %s", keyword, arg), nil
+	}
+	return fmt.Sprintf("%s %s", keyword, arg), nil
+}
+
+// renderMatchArg returns the keyword for a match and its argument as written
+// in the rules language.
+func renderMatchArg(r Rule, names *ruleNames) (string, string, error) {
+	keyword, ok := ruleKeywords[r.Type]
+	if !ok || isRuleAction(r.Type) {
+		return "", "", fmt.Errorf("unsupported rule match %q", r.Type)
+	}
+	var arg string
+	switch r.Type {
+	case ruleMatchSourceZtAddress, ruleMatchDestZtAddress:
+		arg = r.Zt
+	case ruleMatchVlanId:
+		arg = strconv.Itoa(r.VlanId)
+	case ruleMatchVlanPcp:
+		arg = strconv.Itoa(r.VlanPcp)
+	case ruleMatchVlanDei:
+		arg = strconv.Itoa(r.VlanDei)
+	case ruleMatchMacSource, ruleMatchMacDest:
+		arg = r.Mac
+	case ruleMatchIpv4Source, ruleMatchIpv4Dest, ruleMatchIpv6Source, ruleMatchIpv6Dest:
+		arg = r.Ip
+	case ruleMatchIpTos:
+		arg = fmt.Sprintf("0x%x %s", uint64(r.Mask), renderRange(r.Start, r.End))
+	case ruleMatchIpProtocol:
+		arg = strconv.Itoa(r.IpProtocol)
+		for _, p := range ruleIpProtocols {
+			if p.value == r.IpProtocol {
+				arg = p.name
+				break
+			}
+		}
+	case ruleMatchEtherType:
+		arg = fmt.Sprintf("0x%04x", r.EtherType)
+		for _, e := range ruleEtherTypes {
+			if e.value == r.EtherType {
+				arg = e.name
+				break
+			}
+		}
+	case ruleMatchIcmp:
+		code := "-"
+		if r.IcmpCode != nil {
+			code = strconv.Itoa(*r.IcmpCode)
+		}
+		arg = fmt.Sprintf("%d %s", r.IcmpType, code)
+	case ruleMatchSourcePortRange, ruleMatchDestPortRange, ruleMatchFrameSizeRange:
+		arg = renderRange(r.Start, r.End)
+	case ruleMatchCharacteristics:
+		var chrs []string
+		mask := uint64(r.Mask)
+		for _, c := range ruleCharacteristics {
+			if mask&c.bit != 0 {
+				chrs = append(chrs, c.name)
+				mask &^= c.bit
+			}
+		}
+		if mask != 0 || len(chrs) == 0 {
+			return "", "", fmt.Errorf("unsupported packet characteristics mask %016x", uint64(r.Mask))
+		}
+		arg = strings.Join(chrs, ",")
+	case ruleMatchRandom:
+		arg = strconv.FormatFloat(float64(r.Probability)/0xffffffff, 'g', -1, 64)
+	case ruleMatchTagsEqual, ruleMatchTagSender, ruleMatchTagReceiver:
+		arg = fmt.Sprintf("%s %s", names.tagName(r.Id), names.tagValue(r.Id, r.Value))
+	case ruleMatchTagsDifference, ruleMatchTagsBitwiseAnd, ruleMatchTagsBitwiseOr, ruleMatchTagsBitwiseXor:
+		arg = fmt.Sprintf("%s %d", names.tagName(r.Id), r.Value)
+	}
+	return keyword, arg, nil
+}
+
+func renderRange(start, end int) string {
+	if start == end {
+		return strconv.Itoa(start)
+	}
+	return fmt.Sprintf("%d-%d", start, end)
+}
@@ -0,0 +1,82 @@
+package zerotier
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestRulesRoundTrip(t *testing.T) {
+	for name, source := range map[string]string{
+		"default":               defaultRulesSource,
+		"tags and capabilities": testRulesSource,
+	} {
+		compiled, err := compileRules(source, nil)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+
+		// as Central would return the network
+		b, err := json.Marshal(map[string]interface{}{
+			"id": fakeNetworkId,
+			"config": map[string]interface{}{
+				"rules":        compiled.Rules,
+				"capabilities": compiled.Capabilities,
+				"tags":         compiled.Tags,
+			},
+			"capabilitiesByName": compiled.CapabilitiesByName,
+			"tagsByName":         compiled.TagsByName,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n NetworkReadOnly
+		if err := json.Unmarshal(b, &n); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		decompiled, err := decompileRules(&n)
+		if err != nil {
+			t.Errorf("%s: decompiling: %s", name, err)
+			continue
+		}
+		recompiled, err := compileRules(decompiled, nil)
+		if err != nil {
+			t.Errorf("%s: compiling the decompiled rules: %s\n%s", name, err, decompiled)
+			continue
+		}
+		if !reflect.DeepEqual(compiled, recompiled) {
+			t.Errorf("%s: rules changed on a round trip through JSON and decompiling:\n%s", name, decompiled)
+		}
+		if !rulesSourceEquivalent(source, decompiled, nil) {
+			t.Errorf("%s: decompiled rules aren't equivalent to the source:\n%s", name, decompiled)
+		}
+	}
+}
+
+func TestDecompileRulesNames(t *testing.T) {
+	c, err := compileRules("tag department id 1000 enum 1 eng;\ncap ssh id 100 accept dport 22;;\naccept teq department eng;", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &NetworkReadOnly{
+		Config: &ConfigReadOnly{Rules: c.Rules, Capabilities: c.Capabilities, Tags: c.Tags},
+	}
+	// without names from Central, ids stand in for them
+	source, err := decompileRules(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recompiled, err := compileRules(source, nil)
+	if err != nil {
+		t.Fatalf("%s\n%s", err, source)
+	}
+	if !reflect.DeepEqual(recompiled.Rules, c.Rules) || !reflect.DeepEqual(recompiled.Capabilities, c.Capabilities) {
+		t.Errorf("rules changed when decompiled without names:\n%s", source)
+	}
+
+	if _, err := decompileRules(&NetworkReadOnly{}); err == nil {
+		t.Errorf("expected an error for a network without config")
+	}
+}
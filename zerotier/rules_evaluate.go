@@ -0,0 +1,286 @@
+package zerotier
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strings"
+)
+
+// rulePacket describes a frame sent from one member to another, with
+// everything the rule engine can match on.
+type rulePacket struct {
+	etherType  int
+	ipProtocol int
+	sourceIp   net.IP
+	destIp     net.IP
+	sourcePort int
+	destPort   int
+	icmpType   int
+	icmpCode   int
+	ipTos      int
+	frameSize  int
+	// chr bits other than inbound, which depends on which side is filtering
+	characteristics uint64
+
+	sourceAddress string
+	destAddress   string
+	sourceMac     net.HardwareAddr
+	destMac       net.HardwareAddr
+
+	// tag id -> value
+	sourceTags map[int]int
+	destTags   map[int]int
+	// capability ids held by the sender
+	sourceCaps []int
+}
+
+// ruleVerdict is what the rule engine decided, and why.
+type ruleVerdict struct {
+	// accept, drop or redirect
+	action string
+	// the statement that decided, empty if nothing matched and the packet was
+	// dropped by default
+	rule string
+	// the capability the statement belongs to, if any
+	capability string
+	// outbound (filtered by the sender) or inbound (filtered by the receiver)
+	direction string
+}
+
+// the outcome of running through one rule table
+const (
+	ruleResultNoMatch = iota
+	ruleResultDrop
+	ruleResultAccept
+	ruleResultRedirect
+)
+
+const characteristicInbound = 0x8000000000000000
+
+// evaluateRules simulates ZeroTier's rule engine for a packet. Both the sender
+// and the receiver filter every packet, and it has to get past both. On each
+// side the network's rules are evaluated first; if they break or run out
+// without deciding, the sender's capabilities are tried in turn, and the
+// packet is dropped unless one of them accepts it.
+func evaluateRules(c *compiledRules, p *rulePacket) *ruleVerdict {
+	names := newRuleNames(c.CapabilitiesByName, c.TagsByName)
+	var verdict *ruleVerdict
+	for _, inbound := range []bool{false, true} {
+		verdict = evaluateDirection(c, p, inbound, names)
+		if verdict.action == "drop" {
+			return verdict
+		}
+		if verdict.action == "redirect" {
+			// the receiver never sees it
+			return verdict
+		}
+	}
+	return verdict
+}
+
+func evaluateDirection(c *compiledRules, p *rulePacket, inbound bool, names *ruleNames) *ruleVerdict {
+	direction := "outbound"
+	if inbound {
+		direction = "inbound"
+	}
+	result, statement := evaluateRuleSet(c.Rules, p, inbound)
+	switch result {
+	case ruleResultDrop:
+		return &ruleVerdict{action: "drop", rule: renderStatementLine(statement, names), direction: direction}
+	case ruleResultAccept:
+		return &ruleVerdict{action: "accept", rule: renderStatementLine(statement, names), direction: direction}
+	case ruleResultRedirect:
+		return &ruleVerdict{action: "redirect", rule: renderStatementLine(statement, names), direction: direction}
+	}
+
+	held := map[int]bool{}
+	for _, id := range p.sourceCaps {
+		held[id] = true
+	}
+	caps := append([]Capability{}, c.Capabilities...)
+	sort.Slice(caps, func(i, j int) bool { return caps[i].Id < caps[j].Id })
+	for _, capability := range caps {
+		if !held[capability.Id] && !capability.Default {
+			continue
+		}
+		// a drop inside a capability only ends that capability
+		result, statement := evaluateRuleSet(capability.Rules, p, inbound)
+		switch result {
+		case ruleResultAccept:
+			return &ruleVerdict{action: "accept", rule: renderStatementLine(statement, names), capability: names.capName(capability.Id), direction: direction}
+		case ruleResultRedirect:
+			return &ruleVerdict{action: "redirect", rule: renderStatementLine(statement, names), capability: names.capName(capability.Id), direction: direction}
+		}
+	}
+	return &ruleVerdict{action: "drop", direction: direction}
+}
+
+// evaluateRuleSet runs through one rule table, returning the result and the
+// statement (matches and action) that produced it.
+func evaluateRuleSet(rules []Rule, p *rulePacket, inbound bool) (int, []Rule) {
+	matches := true
+	start := 0
+	for i, r := range rules {
+		if isRuleAction(r.Type) {
+			if matches {
+				switch r.Type {
+				case ruleActionDrop:
+					return ruleResultDrop, rules[start : i+1]
+				case ruleActionAccept:
+					return ruleResultAccept, rules[start : i+1]
+				case ruleActionRedirect:
+					return ruleResultRedirect, rules[start : i+1]
+				case ruleActionBreak:
+					return ruleResultNoMatch, rules[start : i+1]
+				}
+				// tee and watch copy the packet and carry on
+			}
+			matches = true
+			start = i + 1
+			continue
+		}
+		m := evaluateMatch(r, p, inbound) != r.Not
+		if r.Or {
+			matches = matches || m
+		} else {
+			matches = matches && m
+		}
+	}
+	return ruleResultNoMatch, nil
+}
+
+func evaluateMatch(r Rule, p *rulePacket, inbound bool) bool {
+	isIp := p.etherType == 0x0800 || p.etherType == 0x86dd
+	localTags, remoteTags := p.sourceTags, p.destTags
+	if inbound {
+		localTags, remoteTags = p.destTags, p.sourceTags
+	}
+	switch r.Type {
+	case ruleMatchSourceZtAddress:
+		return strings.EqualFold(r.Zt, p.sourceAddress)
+	case ruleMatchDestZtAddress:
+		return strings.EqualFold(r.Zt, p.destAddress)
+	case ruleMatchVlanId, ruleMatchVlanPcp, ruleMatchVlanDei:
+		// ZeroTier doesn't carry VLAN tags, so these are always 0
+		return r.VlanId == 0 && r.VlanPcp == 0 && r.VlanDei == 0
+	case ruleMatchMacSource:
+		return macEqual(r.Mac, p.sourceMac)
+	case ruleMatchMacDest:
+		return macEqual(r.Mac, p.destMac)
+	case ruleMatchIpv4Source:
+		return p.etherType == 0x0800 && cidrContains(r.Ip, p.sourceIp)
+	case ruleMatchIpv4Dest:
+		return p.etherType == 0x0800 && cidrContains(r.Ip, p.destIp)
+	case ruleMatchIpv6Source:
+		return p.etherType == 0x86dd && cidrContains(r.Ip, p.sourceIp)
+	case ruleMatchIpv6Dest:
+		return p.etherType == 0x86dd && cidrContains(r.Ip, p.destIp)
+	case ruleMatchIpTos:
+		tos := p.ipTos & int(r.Mask)
+		return isIp && tos >= r.Start && tos <= r.End
+	case ruleMatchIpProtocol:
+		return isIp && p.ipProtocol == r.IpProtocol
+	case ruleMatchEtherType:
+		return p.etherType == r.EtherType
+	case ruleMatchIcmp:
+		icmp := (p.etherType == 0x0800 && p.ipProtocol == 0x01) || (p.etherType == 0x86dd && p.ipProtocol == 0x3a)
+		return icmp && p.icmpType == r.IcmpType && (r.IcmpCode == nil || p.icmpCode == *r.IcmpCode)
+	case ruleMatchSourcePortRange:
+		return isIp && hasPorts(p.ipProtocol) && p.sourcePort >= r.Start && p.sourcePort <= r.End
+	case ruleMatchDestPortRange:
+		return isIp && hasPorts(p.ipProtocol) && p.destPort >= r.Start && p.destPort <= r.End
+	case ruleMatchCharacteristics:
+		cf := p.characteristics
+		if inbound {
+			cf |= characteristicInbound
+		}
+		return cf&uint64(r.Mask) != 0
+	case ruleMatchFrameSizeRange:
+		return p.frameSize >= r.Start && p.frameSize <= r.End
+	case ruleMatchRandom:
+		// can't be simulated; assume the rule fires unless it never can
+		return r.Probability > 0
+	case ruleMatchTagsDifference, ruleMatchTagsBitwiseAnd, ruleMatchTagsBitwiseOr, ruleMatchTagsBitwiseXor, ruleMatchTagsEqual:
+		local, ok := localTags[r.Id]
+		if !ok {
+			return false
+		}
+		remote, ok := remoteTags[r.Id]
+		if !ok {
+			// the sender can't know, so it leaves it to the receiver
+			return !inbound
+		}
+		switch r.Type {
+		case ruleMatchTagsDifference:
+			diff := local - remote
+			if diff < 0 {
+				diff = -diff
+			}
+			return diff <= r.Value
+		case ruleMatchTagsBitwiseAnd:
+			return local&remote == r.Value
+		case ruleMatchTagsBitwiseOr:
+			return local|remote == r.Value
+		case ruleMatchTagsBitwiseXor:
+			return local^remote == r.Value
+		default:
+			return local == r.Value && remote == r.Value
+		}
+	case ruleMatchTagSender:
+		v, ok := p.sourceTags[r.Id]
+		return ok && v == r.Value
+	case ruleMatchTagReceiver:
+		v, ok := p.destTags[r.Id]
+		return ok && v == r.Value
+	}
+	return false
+}
+
+func hasPorts(protocol int) bool {
+	switch protocol {
+	case 0x06, 0x11, 0x84, 0x88:
+		return true
+	}
+	return false
+}
+
+func macEqual(s string, mac net.HardwareAddr) bool {
+	parsed, err := net.ParseMAC(s)
+	return err == nil && bytes.Equal(parsed, mac)
+}
+
+func cidrContains(cidr string, ip net.IP) bool {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	return err == nil && ip != nil && ipnet.Contains(ip)
+}
+
+// renderStatementLine renders the matches and action of one statement on a
+// single line, for showing which rule decided.
+func renderStatementLine(statement []Rule, names *ruleNames) string {
+	if len(statement) == 0 {
+		return ""
+	}
+	var words []string
+	action := statement[len(statement)-1]
+	a, err := renderAction(action)
+	if err != nil {
+		a = action.Type
+	}
+	words = append(words, a)
+	for i, r := range statement[:len(statement)-1] {
+		m, err := renderMatch(r, names)
+		if err != nil {
+			m = r.Type
+		}
+		if i > 0 {
+			if r.Or {
+				words = append(words, "or")
+			} else {
+				words = append(words, "and")
+			}
+		}
+		words = append(words, m)
+	}
+	return strings.Join(words, " ") + ";"
+}
@@ -0,0 +1,182 @@
+package zerotier
+
+import (
+	"net"
+	"testing"
+)
+
+const testEvaluateSource = `
+tag department
+	id 1000
+	enum 1 eng
+	enum 2 ops
+;
+
+cap ssh
+	id 100
+	drop dport 2222;
+	accept ipprotocol tcp and dport 22;
+;
+
+cap web
+	id 101
+	accept ipprotocol tcp and dport 80;
+;
+
+cap alt_ssh
+	id 102
+	accept ipprotocol tcp and dport 2222;
+;
+
+drop
+	not ethertype ipv4
+	and not ethertype arp
+	and not ethertype ipv6
+;
+break ipprotocol tcp and dport 23;
+accept ipprotocol tcp and chr tcp_ack and not chr tcp_syn;
+accept teq department eng;
+accept tseq department ops and dport 8080;
+accept ipprotocol icmp;
+`
+
+func TestEvaluateRules(t *testing.T) {
+	c, err := compileRules(testEvaluateSource, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp := func(port int) *rulePacket {
+		return &rulePacket{
+			etherType:  0x0800,
+			ipProtocol: 0x06,
+			sourceIp:   net.ParseIP("10.0.0.1"),
+			destIp:     net.ParseIP("10.0.0.2"),
+			sourcePort: 40000,
+			destPort:   port,
+			// a new connection
+			characteristics: 0x02,
+		}
+	}
+	with := func(p *rulePacket, f func(p *rulePacket)) *rulePacket {
+		f(p)
+		return p
+	}
+	eng, ops := map[int]int{1000: 1}, map[int]int{1000: 2}
+
+	cases := []struct {
+		name   string
+		packet *rulePacket
+		want   ruleVerdict
+	}{
+		{
+			name:   "ethertype not allowed",
+			packet: with(tcp(22), func(p *rulePacket) { p.etherType = 0x8035 }),
+			want:   ruleVerdict{action: "drop", rule: "drop not ethertype ipv4 and not ethertype arp and not ethertype ipv6;", direction: "outbound"},
+		},
+		{
+			name:   "nothing matches, no capabilities",
+			packet: tcp(22),
+			want:   ruleVerdict{action: "drop", direction: "outbound"},
+		},
+		{
+			name:   "capability accepts",
+			packet: with(tcp(22), func(p *rulePacket) { p.sourceCaps = []int{100} }),
+			want:   ruleVerdict{action: "accept", rule: "accept ipprotocol tcp and dport 22;", capability: "ssh", direction: "inbound"},
+		},
+		{
+			name:   "capability held by the receiver doesn't count",
+			packet: with(tcp(80), func(p *rulePacket) { p.sourceCaps = []int{100} }),
+			want:   ruleVerdict{action: "drop", direction: "outbound"},
+		},
+		{
+			name:   "a drop in one capability falls through to the next",
+			packet: with(tcp(2222), func(p *rulePacket) { p.sourceCaps = []int{102, 100} }),
+			want:   ruleVerdict{action: "accept", rule: "accept ipprotocol tcp and dport 2222;", capability: "alt_ssh", direction: "inbound"},
+		},
+		{
+			name:   "a drop in the only capability drops",
+			packet: with(tcp(2222), func(p *rulePacket) { p.sourceCaps = []int{100} }),
+			want:   ruleVerdict{action: "drop", direction: "outbound"},
+		},
+		{
+			name:   "established connection",
+			packet: with(tcp(5000), func(p *rulePacket) { p.characteristics = 0x10 }),
+			want:   ruleVerdict{action: "accept", rule: "accept ipprotocol tcp and chr tcp_ack and not chr tcp_syn;", direction: "inbound"},
+		},
+		{
+			name:   "break skips the rest of the base rules",
+			packet: with(tcp(23), func(p *rulePacket) { p.characteristics = 0x10 }),
+			want:   ruleVerdict{action: "drop", direction: "outbound"},
+		},
+		{
+			name:   "teq with the same tag on both ends",
+			packet: with(tcp(9000), func(p *rulePacket) { p.sourceTags, p.destTags = eng, eng }),
+			want:   ruleVerdict{action: "accept", rule: "accept teq department eng;", direction: "inbound"},
+		},
+		{
+			name:   "teq with different values",
+			packet: with(tcp(9000), func(p *rulePacket) { p.sourceTags, p.destTags = eng, ops }),
+			want:   ruleVerdict{action: "drop", direction: "outbound"},
+		},
+		{
+			// the sender doesn't know the receiver's tag so lets it through,
+			// and the receiver, without the tag, drops it
+			name:   "teq with the tag only on the sender",
+			packet: with(tcp(9000), func(p *rulePacket) { p.sourceTags = eng }),
+			want:   ruleVerdict{action: "drop", direction: "inbound"},
+		},
+		{
+			name:   "teq with the tag only on the receiver",
+			packet: with(tcp(9000), func(p *rulePacket) { p.destTags = eng }),
+			want:   ruleVerdict{action: "drop", direction: "outbound"},
+		},
+		{
+			name:   "tseq matches the sender on both sides",
+			packet: with(tcp(8080), func(p *rulePacket) { p.sourceTags, p.destTags = ops, eng }),
+			want:   ruleVerdict{action: "accept", rule: "accept tseq department ops and dport 8080;", direction: "inbound"},
+		},
+		{
+			name:   "tseq doesn't match the receiver",
+			packet: with(tcp(8080), func(p *rulePacket) { p.sourceTags, p.destTags = map[int]int{1000: 3}, ops }),
+			want:   ruleVerdict{action: "drop", direction: "outbound"},
+		},
+		{
+			name: "icmp",
+			packet: with(tcp(0), func(p *rulePacket) {
+				p.ipProtocol, p.icmpType, p.characteristics = 0x01, 8, 0
+			}),
+			want: ruleVerdict{action: "accept", rule: "accept ipprotocol icmp;", direction: "inbound"},
+		},
+	}
+	for _, tc := range cases {
+		got := evaluateRules(c, tc.packet)
+		if *got != tc.want {
+			t.Errorf("%s: got %+v, want %+v", tc.name, *got, tc.want)
+		}
+	}
+}
+
+// A default capability applies to every member, and a redirect means the
+// receiver never filters the packet.
+func TestEvaluateRulesDefaultCapabilityAndRedirect(t *testing.T) {
+	c, err := compileRules("cap everyone id 1 default accept dport 53;;\nredirect aabbccddee dport 25;\ndrop;", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dns := &rulePacket{etherType: 0x0800, ipProtocol: 0x11, destPort: 53}
+	if got := evaluateRules(c, dns); got.action != "drop" || got.rule != "drop;" {
+		t.Errorf("a drop in the base rules stops capabilities being tried, got %+v", *got)
+	}
+
+	c, err = compileRules("cap everyone id 1 default accept dport 53;;\nredirect aabbccddee dport 25;", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := evaluateRules(c, dns); got.action != "accept" || got.capability != "everyone" {
+		t.Errorf("default capability: got %+v", *got)
+	}
+	smtp := &rulePacket{etherType: 0x0800, ipProtocol: 0x06, destPort: 25}
+	if got := evaluateRules(c, smtp); got.action != "redirect" || got.direction != "outbound" {
+		t.Errorf("redirect: got %+v", *got)
+	}
+}
@@ -0,0 +1,118 @@
+package zerotier
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// Limits from ZeroTier's node/Constants.hpp. Central rejects, or silently
+// truncates, rules that go over them.
+const (
+	maxNetworkRules        = 1024
+	maxNetworkCapabilities = 128
+	maxNetworkTags         = 128
+	maxCapabilityRules     = 64
+)
+
+// checkRuleLimits returns an error naming each part of the compiled rules that
+// is over ZeroTier's limits.
+func checkRuleLimits(c *compiledRules) error {
+	var problems []string
+	if len(c.Rules) > maxNetworkRules {
+		problems = append(problems, fmt.Sprintf("the network rules compile to %d entries, over the limit of %d", len(c.Rules), maxNetworkRules))
+	}
+	if len(c.Capabilities) > maxNetworkCapabilities {
+		problems = append(problems, fmt.Sprintf("%d capabilities are defined, over the limit of %d", len(c.Capabilities), maxNetworkCapabilities))
+	}
+	if len(c.Tags) > maxNetworkTags {
+		problems = append(problems, fmt.Sprintf("%d tags are defined, over the limit of %d", len(c.Tags), maxNetworkTags))
+	}
+	names := newRuleNames(c.CapabilitiesByName, c.TagsByName)
+	caps := append([]Capability{}, c.Capabilities...)
+	sort.Slice(caps, func(i, j int) bool { return caps[i].Id < caps[j].Id })
+	for _, capability := range caps {
+		if len(capability.Rules) > maxCapabilityRules {
+			problems = append(problems, fmt.Sprintf("capability %s (id %d) compiles to %d entries, over the limit of %d per capability",
+				names.capName(capability.Id), capability.Id, len(capability.Rules), maxCapabilityRules))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("rules are too large for ZeroTier:\n  %s\n\nEach match and each action is one entry.", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// rulesCustomizeDiff compiles the planned rules and fails the plan if they
+// are over ZeroTier's limits. Anything the linter finds is planned as
+// rules_warnings, as CustomizeDiff has no way to warn.
+func rulesCustomizeDiff(d *schema.ResourceDiff, m interface{}) error {
+	for _, k := range []string{"rules_source", "rules_includes", "rule", "capability", "tag"} {
+		if !d.NewValueKnown(k) {
+			return d.SetNewComputed("rules_warnings")
+		}
+	}
+	source, err := rulesSourceFromResourceData(d)
+	if err != nil {
+		return err
+	}
+	if err := setRulesWarnings(d, source); err != nil {
+		return err
+	}
+	compiled, err := compileRules(source, nil)
+	if err != nil {
+		// it's in rules_warnings, and Central has the final say
+		log.Printf("[WARN] unable to compile rules to check their size: %s", err)
+		return nil
+	}
+	return checkRuleLimits(compiled)
+}
+
+// setRulesWarnings lints the rules as written, so that positions are in
+// rules_source or rules_includes, or else in the source rendered from the rule
+// blocks. Whether capabilities are granted to anyone isn't checked, because
+// members can't be seen from here.
+func setRulesWarnings(d *schema.ResourceDiff, rendered string) error {
+	var warnings []string
+	var err error
+	if usesRuleBlocks(d) {
+		warnings, err = LintRules(rendered, nil, nil)
+		for i := range warnings {
+			warnings[i] = "rule blocks, as rendered, " + warnings[i]
+		}
+	} else {
+		warnings, err = LintRules(d.Get("rules_source").(string), rulesIncludes(d), nil)
+	}
+	if err != nil {
+		// Central has the final say
+		warnings = []string{fmt.Sprintf("rules may not compile: %s", err)}
+	}
+	old := []string{}
+	for _, w := range d.Get("rules_warnings").([]interface{}) {
+		old = append(old, w.(string))
+	}
+	if warnings == nil {
+		warnings = []string{}
+	}
+	if reflect.DeepEqual(old, warnings) {
+		return nil
+	}
+	return d.SetNew("rules_warnings", warnings)
+}
+
+// validateMemberTags checks the number of tags on a member, which MaxItems
+// can't do for a map.
+func validateMemberTags(i interface{}, k string) ([]string, []error) {
+	v, ok := i.(map[string]interface{})
+	if !ok {
+		return nil, []error{fmt.Errorf("expected type of %q to be map", k)}
+	}
+	if len(v) > maxNetworkTags {
+		return nil, []error{fmt.Errorf("%q has %d tags, over ZeroTier's limit of %d", k, len(v), maxNetworkTags)}
+	}
+	return nil, nil
+}
@@ -0,0 +1,91 @@
+package zerotier
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/config"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestCheckRuleLimits(t *testing.T) {
+	// n statements, with $i replaced by each one's index
+	repeat := func(n int, statement string) string {
+		var b strings.Builder
+		for i := 0; i < n; i++ {
+			b.WriteString(strings.Replace(statement, "$i", strconv.Itoa(i), -1))
+		}
+		return b.String()
+	}
+	cases := []struct {
+		name, source, err string
+	}{
+		{"at the limits", repeat(maxNetworkRules, "accept;\n") +
+			repeat(maxNetworkCapabilities-1, "cap c$i id $i accept;;\n") +
+			repeat(maxNetworkTags, "tag t$i id $i;\n") +
+			"cap big id 1000\n" + repeat(maxCapabilityRules, "accept;\n") + ";", ""},
+		{"too many rules", repeat(maxNetworkRules+1, "accept;\n"), "the network rules compile to 1025 entries, over the limit of 1024"},
+		{"a match and an action are two entries", repeat(maxNetworkRules/2+1, "accept dport 22;\n"), "the network rules compile to 1026 entries"},
+		{"too many capabilities", repeat(maxNetworkCapabilities+1, "cap c$i id $i accept;;\n"), "129 capabilities are defined, over the limit of 128"},
+		{"too many tags", repeat(maxNetworkTags+1, "tag t$i id $i;\n"), "129 tags are defined, over the limit of 128"},
+		{"a capability with too many rules", "cap big id 7\n" + repeat(maxCapabilityRules+1, "accept;\n") + ";", "capability big (id 7) compiles to 65 entries, over the limit of 64 per capability"},
+	}
+	for _, c := range cases {
+		compiled, err := compileRules(c.source, nil)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		err = checkRuleLimits(compiled)
+		if c.err == "" {
+			if err != nil {
+				t.Errorf("%s: %s", c.name, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: got %v, want an error containing %q", c.name, err, c.err)
+		}
+	}
+
+	// every problem is listed, not just the first
+	compiled, err := compileRules(repeat(maxNetworkRules+1, "accept;\n")+repeat(maxNetworkTags+1, "tag t$i id $i;\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = checkRuleLimits(compiled)
+	if err == nil || !strings.Contains(err.Error(), "1025 entries") || !strings.Contains(err.Error(), "129 tags") {
+		t.Errorf("got %v, want both problems", err)
+	}
+}
+
+// Rules over the limits fail the plan, rather than the apply.
+func TestRulesLimitsPlanned(t *testing.T) {
+	c, err := config.NewRawConfig(map[string]interface{}{
+		"network_id":     fakeNetworkId,
+		"rules_source":   "include many",
+		"rules_includes": map[string]interface{}{"many": strings.Repeat("accept;\n", maxNetworkRules+1)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = resourceZeroTierNetworkRules().Diff(nil, terraform.NewResourceConfig(c), nil)
+	if err == nil || !strings.Contains(err.Error(), "rules are too large for ZeroTier") {
+		t.Errorf("got %v, want the plan to fail", err)
+	}
+}
+
+func TestValidateMemberTags(t *testing.T) {
+	tags := map[string]interface{}{}
+	for i := 0; i < maxNetworkTags; i++ {
+		tags[strconv.Itoa(i)] = 1
+	}
+	if _, errs := validateMemberTags(tags, "tags"); len(errs) > 0 {
+		t.Errorf("%d tags: %v", len(tags), errs)
+	}
+	tags["1000"] = 1
+	if _, errs := validateMemberTags(tags, "tags"); len(errs) != 1 || !strings.Contains(errs[0].Error(), `"tags" has 129 tags, over ZeroTier's limit of 128`) {
+		t.Errorf("%d tags: got %v", len(tags), errs)
+	}
+	if _, errs := validateMemberTags("nope", "tags"); len(errs) != 1 {
+		t.Errorf("not a map: got %v", errs)
+	}
+}
@@ -0,0 +1,96 @@
+package zerotier
+
+import (
+	"fmt"
+	"sort"
+)
+
+// LintRules looks for mistakes in rules source that still compile: statements
+// that can never be reached, capabilities and tags sharing an id, tags used by
+// id but never defined, and capabilities no member holds. includes are the
+// snippets from rules_includes, if any. granted is the set of capability ids
+// held by at least one member; pass nil to skip that check.
+func LintRules(source string, includes map[string]string, granted map[int]bool) ([]string, error) {
+	p, err := parseRules(source, includes)
+	if err != nil {
+		return nil, err
+	}
+	type warning struct {
+		tok ruleToken
+		msg string
+	}
+	var found []warning
+	warn := func(tok ruleToken, format string, args ...interface{}) {
+		found = append(found, warning{tok, fmt.Sprintf(format, args...)})
+	}
+
+	// anything after an action with no matches, in the same rule set
+	final := map[string]ruleStatement{}
+	for _, s := range p.statements {
+		if f, ok := final[s.capability]; ok {
+			warn(s.tok, "%s is unreachable, because %s on %s always applies first", s.tok.text, f.tok.text, f.tok.where())
+			continue
+		}
+		if len(s.rules) == 1 && s.rules[0].Type != ruleActionTee && s.rules[0].Type != ruleActionWatch {
+			final[s.capability] = s
+		}
+	}
+
+	checkDuplicateIds := func(kind string, defs []ruleDefinition) {
+		first := map[int]ruleDefinition{}
+		for _, def := range defs {
+			if f, ok := first[def.id]; ok {
+				warn(def.tok, "%s %s has the same id (%d) as %s %s on %s", kind, def.name, def.id, kind, f.name, f.tok.where())
+				continue
+			}
+			first[def.id] = def
+		}
+	}
+	checkDuplicateIds("tag", p.tagDefs)
+	checkDuplicateIds("capability", p.capDefs)
+
+	defined := map[int]bool{}
+	for _, t := range p.out.Tags {
+		defined[t.Id] = true
+	}
+	for _, s := range p.statements {
+		undefined := map[int]bool{}
+		for _, r := range s.rules {
+			switch r.Type {
+			case ruleMatchTagsDifference, ruleMatchTagsBitwiseAnd, ruleMatchTagsBitwiseOr, ruleMatchTagsBitwiseXor,
+				ruleMatchTagsEqual, ruleMatchTagSender, ruleMatchTagReceiver:
+				if !defined[r.Id] {
+					undefined[r.Id] = true
+				}
+			}
+		}
+		ids := make([]int, 0, len(undefined))
+		for id := range undefined {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		for _, id := range ids {
+			warn(s.tok, "tag id %d is used but no tag with that id is defined", id)
+		}
+	}
+
+	if granted != nil {
+		for i, def := range p.capDefs {
+			if !granted[def.id] && !p.out.Capabilities[i].Default {
+				warn(def.tok, "capability %s (id %d) isn't granted to any member", def.name, def.id)
+			}
+		}
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].tok.source != found[j].tok.source {
+			return found[i].tok.source < found[j].tok.source
+		}
+		return found[i].tok.line < found[j].tok.line
+	})
+	var warnings []string
+	for _, w := range found {
+		warnings = append(warnings, fmt.Sprintf("%s: %s", w.tok.where(), w.msg))
+	}
+	return warnings, nil
+}
@@ -0,0 +1,115 @@
+package zerotier
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/config"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestLintRules(t *testing.T) {
+	source := `tag a id 1;
+tag b id 1;
+cap ssh id 100 accept dport 22;;
+cap web id 101 accept dport 80;;
+cap everyone id 102 default accept dport 53;;
+accept teq a 1;
+accept treq 7 1;
+accept;
+drop;
+`
+	warnings, err := LintRules(source, nil, map[int]bool{100: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"line 2: tag b has the same id (1) as tag a on line 1",
+		"line 4: capability web (id 101) isn't granted to any member",
+		"line 7: tag id 7 is used but no tag with that id is defined",
+		"line 9: drop is unreachable, because accept on line 8 always applies first",
+	}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("got warnings:\n  %s\nwant:\n  %s", strings.Join(warnings, "\n  "), strings.Join(want, "\n  "))
+	}
+
+	// without members, grants aren't checked
+	warnings, err = LintRules(source, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range warnings {
+		if strings.Contains(w, "isn't granted") {
+			t.Errorf("checked grants without members: %s", w)
+		}
+	}
+
+	// a statement in a capability doesn't make the base rules unreachable
+	warnings, err = LintRules("cap c id 1 accept; drop;;\naccept;", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], "line 1: drop is unreachable") {
+		t.Errorf("got %v", warnings)
+	}
+}
+
+// The plan lints what rules_source includes, and the rule blocks, and plans
+// what it finds as rules_warnings.
+func TestRulesWarningsPlanned(t *testing.T) {
+	r := resourceZeroTierNetworkRules()
+	plan := func(raw map[string]interface{}) []string {
+		t.Helper()
+		c, err := config.NewRawConfig(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		diff, err := r.Diff(nil, terraform.NewResourceConfig(c), nil)
+		if err != nil {
+			t.Fatalf("plan: %s", err)
+		}
+		var warnings []string
+		for i := 0; ; i++ {
+			a, ok := diff.Attributes["rules_warnings."+strconv.Itoa(i)]
+			if !ok {
+				return warnings
+			}
+			warnings = append(warnings, a.New)
+		}
+	}
+
+	warnings := plan(map[string]interface{}{
+		"network_id":     fakeNetworkId,
+		"rules_source":   "include baseline\ndrop;",
+		"rules_includes": map[string]interface{}{"baseline": "# allow everything\naccept;"},
+	})
+	want := []string{"line 2: drop is unreachable, because accept on rules_includes.baseline line 2 always applies first"}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("with includes, got %v, want %v", warnings, want)
+	}
+
+	warnings = plan(map[string]interface{}{
+		"network_id": fakeNetworkId,
+		"rule": []interface{}{
+			map[string]interface{}{"action": "accept"},
+			map[string]interface{}{"action": "drop"},
+		},
+	})
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], "rule blocks, as rendered, line 2: drop is unreachable") {
+		t.Errorf("with rule blocks, got %v", warnings)
+	}
+
+	warnings = plan(map[string]interface{}{
+		"network_id":   fakeNetworkId,
+		"rules_source": "accept frob;",
+	})
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], "rules may not compile: ") {
+		t.Errorf("with rules that don't compile, got %v", warnings)
+	}
+
+	if warnings := plan(map[string]interface{}{"network_id": fakeNetworkId}); len(warnings) != 0 {
+		t.Errorf("the default rules have warnings: %v", warnings)
+	}
+}
@@ -0,0 +1,212 @@
+package zerotier
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Macros are defined in rules source with
+//
+//	macro allow_port($proto,$port)
+//		accept ipprotocol $proto and dport $port;
+//	;
+//
+// and used with `include allow_port(tcp,22)`. An include can also name a
+// snippet from rules_includes, whose arguments are $1, $2 and so on.
+
+// how deep includes can nest, so that a macro including itself is an error
+// rather than a hang
+const maxIncludeDepth = 16
+
+type ruleMacro struct {
+	params []string
+	body   []ruleToken
+}
+
+func usesRuleMacros(tokens []ruleToken) bool {
+	for _, t := range tokens {
+		if t.text == "macro" || t.text == "include" {
+			return true
+		}
+	}
+	return false
+}
+
+// expandRulesSource expands macros and includes so that the result can be sent
+// to Central. Sources that use neither are returned untouched.
+func expandRulesSource(source string, includes map[string]string) (string, error) {
+	tokens := tokenizeRules(source)
+	if !usesRuleMacros(tokens) {
+		return source, nil
+	}
+	expanded, err := expandRuleMacros(tokens, includes)
+	if err != nil {
+		return "", err
+	}
+	var buf strings.Builder
+	startOfLine := true
+	for _, t := range expanded {
+		if t.text == ";" {
+			buf.WriteString(";\n")
+			startOfLine = true
+			continue
+		}
+		if !startOfLine {
+			buf.WriteString(" ")
+		}
+		buf.WriteString(t.text)
+		startOfLine = false
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+func expandRuleMacros(tokens []ruleToken, includes map[string]string) ([]ruleToken, error) {
+	return expandRuleMacrosDepth(tokens, includes, map[string]ruleMacro{}, 0)
+}
+
+func expandRuleMacrosDepth(tokens []ruleToken, includes map[string]string, macros map[string]ruleMacro, depth int) ([]ruleToken, error) {
+	if !usesRuleMacros(tokens) {
+		return tokens, nil
+	}
+	var out []ruleToken
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		switch tok.text {
+		case "macro":
+			name, params, next, err := parseMacroHeader(tokens, i+1, tok)
+			if err != nil {
+				return nil, err
+			}
+			for _, param := range params {
+				if !strings.HasPrefix(param, "$") || len(param) < 2 {
+					return nil, &ruleError{tok: tok, msg: fmt.Sprintf("macro %s: parameter %q should start with $", name, param)}
+				}
+			}
+			body, next, err := macroBody(tokens, next, tok, name)
+			if err != nil {
+				return nil, err
+			}
+			macros[name] = ruleMacro{params: params, body: body}
+			i = next
+		case "include":
+			if depth >= maxIncludeDepth {
+				return nil, &ruleError{tok: tok, msg: "includes are nested too deeply, does a macro include itself?"}
+			}
+			name, args, next, err := parseMacroHeader(tokens, i+1, tok)
+			if err != nil {
+				return nil, err
+			}
+			macro, ok := macros[name]
+			if !ok {
+				snippet, isSnippet := includes[name]
+				if !isSnippet {
+					return nil, &ruleError{tok: tok, msg: fmt.Sprintf("include of %q, which is neither a macro nor in rules_includes", name)}
+				}
+				macro.body = tokenizeRules(snippet)
+				for j := range macro.body {
+					macro.body[j].source = "rules_includes." + name
+				}
+				for j := range args {
+					macro.params = append(macro.params, "$"+strconv.Itoa(j+1))
+				}
+			}
+			if len(args) != len(macro.params) {
+				return nil, &ruleError{tok: tok, msg: fmt.Sprintf("%s takes %d arguments, got %d", name, len(macro.params), len(args))}
+			}
+			expanded, err := expandRuleMacrosDepth(substituteMacroArgs(macro, args), includes, macros, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, expanded...)
+			i = next
+		default:
+			out = append(out, tok)
+		}
+	}
+	return out, nil
+}
+
+// parseMacroHeader reads `name` or `name($a,$b)` starting at tokens[i], and
+// returns the index of its last token.
+func parseMacroHeader(tokens []ruleToken, i int, keyword ruleToken) (string, []string, int, error) {
+	if i >= len(tokens) || tokens[i].text == ";" {
+		return "", nil, 0, &ruleError{tok: keyword, msg: fmt.Sprintf("expected a name after %s", keyword.text)}
+	}
+	header := tokens[i].text
+	for strings.Contains(header, "(") && !strings.HasSuffix(header, ")") {
+		i++
+		if i >= len(tokens) || tokens[i].text == ";" {
+			return "", nil, 0, &ruleError{tok: keyword, msg: fmt.Sprintf("missing ')' after %s %s", keyword.text, header)}
+		}
+		header += tokens[i].text
+	}
+	open := strings.Index(header, "(")
+	if open < 0 {
+		return header, nil, i, nil
+	}
+	name := header[:open]
+	var args []string
+	if inner := strings.TrimSpace(header[open+1 : len(header)-1]); inner != "" {
+		for _, arg := range strings.Split(inner, ",") {
+			args = append(args, strings.TrimSpace(arg))
+		}
+	}
+	return name, args, i, nil
+}
+
+// macroBody collects a macro's statements up to the ';' that ends it, and
+// returns the index of that ';'. Capability definitions inside the macro end
+// with a ';' of their own.
+func macroBody(tokens []ruleToken, i int, keyword ruleToken, name string) ([]ruleToken, int, error) {
+	var body []ruleToken
+	startOfStatement := true
+	openCaps := 0
+	for i++; i < len(tokens); i++ {
+		tok := tokens[i]
+		if tok.text == ";" {
+			if startOfStatement {
+				if openCaps == 0 {
+					return body, i, nil
+				}
+				openCaps--
+			}
+			startOfStatement = true
+		} else if startOfStatement && tok.text == "include" {
+			// includes don't end with ';', so the next token starts a statement
+			_, _, last, err := parseMacroHeader(tokens, i+1, tok)
+			if err != nil {
+				return nil, 0, err
+			}
+			body = append(body, tokens[i:last+1]...)
+			i = last
+			continue
+		} else {
+			if startOfStatement && tok.text == "cap" {
+				openCaps++
+			}
+			startOfStatement = false
+		}
+		body = append(body, tok)
+	}
+	return nil, 0, &ruleError{tok: keyword, msg: fmt.Sprintf("macro %s is missing its closing ';'", name)}
+}
+
+func substituteMacroArgs(macro ruleMacro, args []string) []ruleToken {
+	// longest first, so $port isn't mistaken for $p
+	order := make([]int, len(macro.params))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return len(macro.params[order[a]]) > len(macro.params[order[b]]) })
+
+	body := make([]ruleToken, len(macro.body))
+	for i, tok := range macro.body {
+		for _, j := range order {
+			tok.text = strings.Replace(tok.text, macro.params[j], args[j], -1)
+		}
+		body[i] = tok
+	}
+	return body
+}
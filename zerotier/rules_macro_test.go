@@ -0,0 +1,83 @@
+package zerotier
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpandRulesSource(t *testing.T) {
+	source := `macro allow_port($proto,$port)
+	accept ipprotocol $proto and dport $port;
+;
+macro allow_ssh
+	include allow_port(tcp,22)
+;
+include allow_ssh
+include allow_port(udp, 53)
+include baseline(ipv4,arp)
+`
+	includes := map[string]string{"baseline": "drop not ethertype $1 and not ethertype $2;\naccept;"}
+	expanded, err := expandRulesSource(source, includes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `accept ipprotocol tcp and dport 22;
+accept ipprotocol udp and dport 53;
+drop not ethertype ipv4 and not ethertype arp;
+accept;`
+	if expanded != want {
+		t.Errorf("expanded to:\n%s\nwant:\n%s", expanded, want)
+	}
+
+	// sources without macros or includes are sent as they are
+	if expanded, err := expandRulesSource(defaultRulesSource, nil); err != nil || expanded != defaultRulesSource {
+		t.Errorf("the default rules changed: %q, %v", expanded, err)
+	}
+}
+
+// $port is replaced whole, not as $p followed by "ort"
+func TestExpandRulesSourceParameterPrefixes(t *testing.T) {
+	expanded, err := expandRulesSource("macro m($p,$port)\naccept ipprotocol $p and dport $port;\n;\ninclude m(tcp,443)", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expanded != "accept ipprotocol tcp and dport 443;" {
+		t.Errorf("expanded to %q", expanded)
+	}
+}
+
+// Macros can define capabilities, whose rules end with a ';' of their own.
+func TestExpandRulesSourceCapabilityInMacro(t *testing.T) {
+	c, err := compileRules("macro port_cap($name,$id,$port)\ncap $name id $id accept dport $port;;\n;\ninclude port_cap(ssh,100,22)\ninclude port_cap(web,101,80)\naccept;", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.CapabilitiesByName["ssh"] != 100 || c.CapabilitiesByName["web"] != 101 || len(c.Rules) != 1 {
+		t.Errorf("compiled to capabilities %v and rules %+v", c.CapabilitiesByName, c.Rules)
+	}
+}
+
+func TestExpandRulesSourceErrors(t *testing.T) {
+	includes := map[string]string{
+		"broken": "# a comment\naccept\n\tfrob 1;",
+	}
+	cases := []struct {
+		name, source, err string
+	}{
+		{"unknown include", "include nope", `line 1, column 1: include of "nope", which is neither a macro nor in rules_includes`},
+		{"too few arguments", "macro m($a,$b)\naccept;\n;\ninclude m(1)", "line 4, column 1: m takes 2 arguments, got 1"},
+		{"bad parameter", "macro m(a)\naccept;\n;", `macro m: parameter "a" should start with $`},
+		{"unclosed macro", "macro m\naccept;", "line 1, column 1: macro m is missing its closing ';'"},
+		{"missing name", "accept;\ninclude;", "line 2, column 1: expected a name after include"},
+		{"missing parenthesis", "include m(1, 2", "missing ')' after include m(1,"},
+		{"recursive", "macro m\ninclude m\n;\ninclude m", "includes are nested too deeply, does a macro include itself?"},
+		// errors in a snippet point into the snippet, not the source
+		{"error in a snippet", "drop not ethertype ipv4;\ninclude broken", `rules_includes.broken line 3, column 2: unknown match "frob"`},
+	}
+	for _, c := range cases {
+		_, err := compileRules(c.source, includes)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: got %v, want an error containing %q", c.name, err, c.err)
+		}
+	}
+}
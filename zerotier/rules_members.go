@@ -0,0 +1,220 @@
+package zerotier
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// checkMemberRules returns an error if a member is given capabilities or tags
+// that the network's rules don't define, or tag values outside a tag's enums
+// and flags. Granting an undefined capability does nothing, so it's almost
+// always a typo.
+func checkMemberRules(n *NetworkReadOnly, caps []int, tags [][]int) error {
+	definedCaps := map[int]bool{}
+	definedTags := map[int]bool{}
+	if n.Config != nil {
+		for _, c := range n.Config.Capabilities {
+			definedCaps[c.Id] = true
+		}
+		for _, t := range n.Config.Tags {
+			definedTags[t.Id] = true
+		}
+	}
+	for _, id := range n.CapabilitiesByName {
+		definedCaps[id] = true
+	}
+	tagsById := map[int]TagByName{}
+	for _, t := range n.TagsByName {
+		definedTags[t.Id] = true
+		tagsById[t.Id] = t
+	}
+	names := newRuleNames(n.CapabilitiesByName, n.TagsByName)
+
+	var problems []string
+	for _, id := range caps {
+		if !definedCaps[id] {
+			problems = append(problems, fmt.Sprintf("capability %d is not defined (defined: %s)", id, describeIds(definedCaps, names.capName)))
+		}
+	}
+	for _, tuple := range tags {
+		id, value := tuple[0], tuple[1]
+		if !definedTags[id] {
+			problems = append(problems, fmt.Sprintf("tag %d is not defined (defined: %s)", id, describeIds(definedTags, names.tagName)))
+			continue
+		}
+		if t, ok := tagsById[id]; ok && !isTagValue(t, value) {
+			problems = append(problems, fmt.Sprintf("%d is not a value of tag %s (id %d), which has %s", value, names.tagName(id), id, describeTagValues(t)))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("network %s's rules don't match this member:\n  %s", n.Id, strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// isTagValue reports whether value is one of a tag's enums, or made up of its
+// flags. Tags with neither can take any value.
+func isTagValue(t TagByName, value int) bool {
+	if len(t.Enums) == 0 && len(t.Flags) == 0 {
+		return true
+	}
+	for _, v := range t.Enums {
+		if v == value {
+			return true
+		}
+	}
+	if len(t.Flags) == 0 {
+		return false
+	}
+	flags := 0
+	for _, bit := range t.Flags {
+		flags |= 1 << uint(bit)
+	}
+	return value&^flags == 0
+}
+
+func describeIds(ids map[int]bool, name func(int) string) string {
+	if len(ids) == 0 {
+		return "none"
+	}
+	sorted := make([]int, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Ints(sorted)
+	described := make([]string, len(sorted))
+	for i, id := range sorted {
+		described[i] = fmt.Sprintf("%s=%d", name(id), id)
+	}
+	return strings.Join(described, ", ")
+}
+
+func describeTagValues(t TagByName) string {
+	var described []string
+	for name, v := range t.Enums {
+		described = append(described, fmt.Sprintf("%s=%d", name, v))
+	}
+	for name, bit := range t.Flags {
+		described = append(described, fmt.Sprintf("flag %s=%d", name, 1<<uint(bit)))
+	}
+	sort.Strings(described)
+	return strings.Join(described, ", ")
+}
+
+// resolveMemberRules fills in capabilities and tags given by name from the
+// network's rules, then checks them all against it. It runs on apply rather
+// than plan, because the network's rules may be changing in the same apply.
+//
+// With names, the capabilities and tags are made from them alone. The numbers
+// in state are the previous apply's, so starting from them would keep grants
+// that have since been taken out of the config.
+func resolveMemberRules(client *ZeroTierClient, d *schema.ResourceData, member *Member) error {
+	byName := usesMemberNames(d)
+	if !byName && len(member.Config.Capabilities) == 0 && len(member.Config.Tags) == 0 {
+		return nil
+	}
+	n, err := client.GetNetwork(member.NetworkId)
+	if err != nil {
+		return fmt.Errorf("unable to read network %s to check capabilities and tags: %s", member.NetworkId, err)
+	}
+
+	if byName {
+		caps, err := resolveCapabilities(d.Get("capability_names").([]interface{}), n.CapabilitiesByName)
+		if err != nil {
+			return fmt.Errorf("capability_names: %s in network %s's rules", err, n.Id)
+		}
+		tags, err := resolveTagValues(d.Get("tag_values").(map[string]interface{}), n.TagsByName)
+		if err != nil {
+			return fmt.Errorf("tag_values: %s in network %s's rules", err, n.Id)
+		}
+		member.Config.Capabilities = append([]int{}, caps...)
+		member.Config.Tags = [][]int{}
+		for id, value := range tags {
+			member.Config.Tags = append(member.Config.Tags, []int{id, value})
+		}
+		sort.Slice(member.Config.Tags, func(i, j int) bool { return member.Config.Tags[i][0] < member.Config.Tags[j][0] })
+	}
+
+	return checkMemberRules(n, member.Config.Capabilities, member.Config.Tags)
+}
+
+// usesMemberNames is true when capabilities or tags are given by name, in
+// which case the numbers follow from them.
+func usesMemberNames(d *schema.ResourceData) bool {
+	return len(d.Get("capability_names").([]interface{})) > 0 || len(d.Get("tag_values").(map[string]interface{})) > 0
+}
+
+func memberNamesDiffSuppress(k, old, new string, d *schema.ResourceData) bool {
+	return usesMemberNames(d)
+}
+
+// setCapabilityNames maps a member's capabilities back to names, so renumbering
+// a capability in the rules doesn't show up as a change. Capabilities without a
+// name are left as their id.
+func setCapabilityNames(d *schema.ResourceData, n *NetworkReadOnly, member *MemberReadOnly) {
+	names := map[int]string{}
+	for name, id := range n.CapabilitiesByName {
+		names[id] = name
+	}
+	// keep the spelling from config, eg an id, when it means the same thing
+	for _, raw := range d.Get("capability_names").([]interface{}) {
+		if ids, err := resolveCapabilities([]interface{}{raw}, n.CapabilitiesByName); err == nil {
+			names[ids[0]] = raw.(string)
+		}
+	}
+	capNames := make([]string, len(member.Config.Capabilities))
+	for i, id := range member.Config.Capabilities {
+		if name, ok := names[id]; ok {
+			capNames[i] = name
+		} else {
+			capNames[i] = strconv.Itoa(id)
+		}
+	}
+	d.Set("capability_names", capNames)
+}
+
+// setTagValues maps a member's tags back to names, and values to enum names
+// where there is one.
+func setTagValues(d *schema.ResourceData, n *NetworkReadOnly, member *MemberReadOnly) {
+	byId := map[int]string{}
+	for name, t := range n.TagsByName {
+		byId[t.Id] = name
+	}
+	// keep the spelling from config, eg a flag name, when it means the same thing
+	configured := map[int]map[int][2]string{}
+	for key, raw := range d.Get("tag_values").(map[string]interface{}) {
+		resolved, err := resolveTagValues(map[string]interface{}{key: raw}, n.TagsByName)
+		if err != nil {
+			continue
+		}
+		for id, value := range resolved {
+			configured[id] = map[int][2]string{value: {key, raw.(string)}}
+		}
+	}
+	tagValues := map[string]string{}
+	for _, tuple := range member.Config.Tags {
+		id, value := tuple[0], tuple[1]
+		if spelling, ok := configured[id][value]; ok {
+			tagValues[spelling[0]] = spelling[1]
+			continue
+		}
+		key, named := byId[id]
+		if !named {
+			key = strconv.Itoa(id)
+		}
+		tagValues[key] = strconv.Itoa(value)
+		if named {
+			for enum, v := range n.TagsByName[key].Enums {
+				if v == value {
+					tagValues[key] = enum
+				}
+			}
+		}
+	}
+	d.Set("tag_values", tagValues)
+}
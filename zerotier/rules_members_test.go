@@ -0,0 +1,83 @@
+package zerotier
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestMemberNamesReplaceGrants(t *testing.T) {
+	f := newFakeController(t)
+	defer f.Close()
+	f.networks[fakeNetworkId] = &NetworkReadOnly{
+		Id:                 fakeNetworkId,
+		Config:             &ConfigReadOnly{},
+		CapabilitiesByName: map[string]int{"ssh": 1000, "web": 1001},
+		TagsByName: map[string]TagByName{
+			"dept": {Tag: Tag{Id: 2000}, Enums: map[string]int{"eng": 1, "ops": 2}},
+			"team": {Tag: Tag{Id: 2001}},
+		},
+	}
+	r := resourceZeroTierMember()
+	path := "/network/" + fakeNetworkId + "/member/a1b2c3d4e5"
+
+	steps := []struct {
+		name      string
+		capNames  []interface{}
+		tagValues map[string]interface{}
+		caps      []int
+		tags      [][]int
+	}{
+		{
+			name:      "create",
+			capNames:  []interface{}{"ssh"},
+			tagValues: map[string]interface{}{"dept": "eng", "team": "7"},
+			caps:      []int{1000},
+			tags:      [][]int{{2000, 1}, {2001, 7}},
+		},
+		{
+			name:      "rename",
+			capNames:  []interface{}{"web"},
+			tagValues: map[string]interface{}{"dept": "ops", "team": "7"},
+			caps:      []int{1001},
+			tags:      [][]int{{2000, 2}, {2001, 7}},
+		},
+		{
+			name:      "remove a capability and a tag",
+			tagValues: map[string]interface{}{"dept": "ops"},
+			caps:      []int{},
+			tags:      [][]int{{2000, 2}},
+		},
+		{
+			name:     "remove all tags",
+			capNames: []interface{}{"ssh", "web"},
+			caps:     []int{1000, 1001},
+			tags:     [][]int{},
+		},
+	}
+
+	var state *terraform.InstanceState
+	for _, step := range steps {
+		raw := map[string]interface{}{
+			"network_id": fakeNetworkId,
+			"node_id":    "a1b2c3d4e5",
+		}
+		if step.capNames != nil {
+			raw["capability_names"] = step.capNames
+		}
+		if step.tagValues != nil {
+			raw["tag_values"] = step.tagValues
+		}
+		state = f.apply(r, state, raw)
+
+		var posted Member
+		f.lastPosted(path, &posted)
+		if !reflect.DeepEqual(posted.Config.Capabilities, step.caps) {
+			t.Errorf("%s: POSTed capabilities %v, want %v", step.name, posted.Config.Capabilities, step.caps)
+		}
+		if !reflect.DeepEqual(posted.Config.Tags, step.tags) {
+			t.Errorf("%s: POSTed tags %v, want %v", step.name, posted.Config.Tags, step.tags)
+		}
+	}
+}